@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// reorderBuffer is the number of chunks that may be held in memory while
+// waiting on an earlier chunk, on top of the ones being downloaded
+const reorderBuffer = 16
+
+// assembler appends downloaded chunks to the output file in playlist order as
+// soon as every chunk before them is done, holding chunks that arrive early in
+// memory. Progress is recorded in the manifest so that the partial output file
+// can be picked up again by a later run.
+//
+// Chunks that failed to download are skipped and recorded as gaps.
+type assembler struct {
+	chunks   []Chunk
+	manifest *Manifest
+	outPath  string
+	partPath string
+	out      *os.File
+	// stream is set when writing to a stream such as stdout, which can't be
+	// resumed or moved into place
+	stream bool
+	next   int
+	held   map[int]chunkResult
+	gaps   []Gap
+}
+
+// newAssembler opens (or resumes) the partial output file for outPath
+func newAssembler(chunks []Chunk, manifest *Manifest, outPath string) (*assembler, error) {
+	a := &assembler{
+		chunks:   chunks,
+		manifest: manifest,
+		outPath:  outPath,
+		partPath: outPath + ".part",
+		held:     make(map[int]chunkResult),
+	}
+
+	// a previous run may have been writing to a different output path
+	if manifest.Output != "" && manifest.Output != a.partPath {
+		err := os.Rename(manifest.Output, a.partPath)
+		if err != nil {
+			log.Printf("[newAssembler] could not move previous output <%s>: %s", manifest.Output, err)
+		}
+	}
+
+	out, err := os.OpenFile(a.partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.out = out
+
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	next, offset := manifest.ResumePoint(chunks, info.Size())
+	if next > 0 && !a.verifyTail(next, offset) {
+		log.Printf("[newAssembler] partial output does not match manifest, starting over")
+		next, offset = 0, 0
+	}
+
+	// anything past the last recorded chunk is from a write that was cut short
+	err = out.Truncate(offset)
+	if err == nil {
+		_, err = out.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = manifest.Reset(next, a.partPath)
+	}
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	a.next = next
+	for i := 0; i < next; i++ {
+		if e := manifest.Entry(i); e.Error != "" {
+			a.addGap(i, e.Error)
+		}
+	}
+
+	log.Printf("[newAssembler] writing to <%s> starting at chunk %d (offset %d)", a.partPath, next, offset)
+	return a, nil
+}
+
+// newStreamAssembler writes the chunks to out (e.g. stdout) from the start,
+// without a partial file. The manifest is still kept, but only for the
+// chunks written by this run.
+func newStreamAssembler(chunks []Chunk, manifest *Manifest, out *os.File) (*assembler, error) {
+	a := &assembler{
+		chunks:   chunks,
+		manifest: manifest,
+		outPath:  out.Name(),
+		out:      out,
+		stream:   true,
+		held:     make(map[int]chunkResult),
+	}
+
+	// there's no partial output for a later run to pick up
+	err := manifest.Reset(0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[newStreamAssembler] writing to <%s>", a.outPath)
+	return a, nil
+}
+
+// verifyTail checks the hash of the last recorded chunk against the output
+// file, which catches a partial output that belongs to a different download
+func (a *assembler) verifyTail(n int, offset int64) bool {
+	e := a.manifest.Entry(n - 1)
+	if e.Error != "" {
+		return true
+	}
+	h := sha256.New()
+	_, err := io.Copy(h, io.NewSectionReader(a.out, offset-e.Size, e.Size))
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == e.Hash
+}
+
+// Next returns the index of the next chunk to be appended
+func (a *assembler) Next() int {
+	return a.next
+}
+
+// Add accepts a downloaded chunk and appends it, along with any held chunks
+// that follow it, to the output file. A result with an error is recorded as a
+// gap instead. It returns the number of chunks appended or skipped.
+func (a *assembler) Add(res chunkResult) (int, error) {
+	a.held[res.Chunk.Index] = res
+
+	appended := 0
+	for {
+		r, ok := a.held[a.next]
+		if !ok {
+			return appended, nil
+		}
+		delete(a.held, a.next)
+
+		entry := ManifestEntry{
+			Index: r.Chunk.Index,
+			Name:  r.Chunk.Name,
+			Size:  int64(len(r.Data)),
+			Hash:  r.Hash,
+		}
+		if r.Err != nil {
+			entry.Error = r.Err.Error()
+			a.addGap(a.next, entry.Error)
+		} else {
+			_, err := a.out.Write(r.Data)
+			if err != nil {
+				return appended, err
+			}
+		}
+		err := a.manifest.Append(entry)
+		if err != nil {
+			return appended, err
+		}
+
+		a.next++
+		appended++
+	}
+}
+
+func (a *assembler) addGap(i int, reason string) {
+	start := 0.0
+	for _, c := range a.chunks[:i] {
+		start += c.Length
+	}
+	a.gaps = append(a.gaps, Gap{
+		Index:  i,
+		Name:   a.chunks[i].Name,
+		Start:  start,
+		Length: a.chunks[i].Length,
+		Error:  reason,
+	})
+}
+
+// Gaps returns the chunks that were skipped, including ones skipped by a
+// previous run
+func (a *assembler) Gaps() []Gap {
+	return a.gaps
+}
+
+// Close closes the partial output file, leaving it in place to be resumed. A
+// stream is left open.
+func (a *assembler) Close() error {
+	a.held = make(map[int]chunkResult)
+	err := a.manifest.Close()
+	if a.stream {
+		return err
+	}
+	closeErr := a.out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Finish closes the output file and moves it to its final path once every
+// chunk has been appended
+func (a *assembler) Finish() error {
+	if a.next != len(a.chunks) {
+		a.Close()
+		return fmt.Errorf("error: only %d of %d chunks were written to the output", a.next, len(a.chunks))
+	}
+
+	err := a.Close()
+	if err != nil || a.stream {
+		return err
+	}
+
+	return os.Rename(a.partPath, a.outPath)
+}
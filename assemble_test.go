@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssemblerAdd(t *testing.T) {
+	data := [][]byte{[]byte("aaaa"), []byte("bb"), []byte("cccccc"), []byte("d")}
+
+	tests := []struct {
+		name         string
+		order        []int
+		failed       []int
+		wantAppended []int
+		want         string
+	}{
+		{"in order", []int{0, 1, 2, 3}, nil, []int{1, 1, 1, 1}, "aaaabbccccccd"},
+		{"reversed", []int{3, 2, 1, 0}, nil, []int{0, 0, 0, 4}, "aaaabbccccccd"},
+		{"shuffled", []int{1, 0, 3, 2}, nil, []int{0, 2, 0, 2}, "aaaabbccccccd"},
+		{"gap", []int{0, 2, 1, 3}, []int{1}, []int{1, 0, 2, 1}, "aaaaccccccd"},
+		{"gap held behind an earlier chunk", []int{1, 2, 3, 0}, []int{1, 3}, []int{0, 0, 0, 4}, "aaaacccccc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outPath := filepath.Join(dir, "out.ts")
+			m := &Manifest{path: filepath.Join(dir, ManifestFile)}
+			a, err := newAssembler(testChunks(10, 10, 10, 10), m, outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed := make(map[int]bool, len(tt.failed))
+			for _, i := range tt.failed {
+				failed[i] = true
+			}
+
+			for n, i := range tt.order {
+				res := chunkResult{Chunk: a.chunks[i], Data: data[i]}
+				if failed[i] {
+					res = chunkResult{Chunk: a.chunks[i], Err: errors.New("error: got HTTP 404")}
+				}
+				appended, err := a.Add(res)
+				if err != nil {
+					t.Fatalf("Add(%d) error = %v", i, err)
+				}
+				if appended != tt.wantAppended[n] {
+					t.Errorf("Add(%d) = %d, want %d", i, appended, tt.wantAppended[n])
+				}
+			}
+
+			err = a.Finish()
+			if err != nil {
+				t.Fatalf("Finish() error = %v", err)
+			}
+			got, err := ioutil.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(outPath + ".part"); !os.IsNotExist(err) {
+				t.Errorf("Finish() left the partial output behind")
+			}
+			if len(a.Gaps()) != len(tt.failed) {
+				t.Errorf("Gaps() = %d gaps, want %d", len(a.Gaps()), len(tt.failed))
+			}
+			if m.Len() != len(tt.order) {
+				t.Errorf("manifest has %d entries, want %d", m.Len(), len(tt.order))
+			}
+		})
+	}
+}
+
+func TestAssemblerFinishIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out.ts")
+	m := &Manifest{path: filepath.Join(dir, ManifestFile)}
+	a, err := newAssembler(testChunks(10, 10), m, outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second chunk arrived, but not the first
+	_, err = a.Add(chunkResult{Chunk: a.chunks[1], Data: []byte("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Finish() == nil {
+		t.Errorf("Finish() with chunks missing succeeded")
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("Finish() with chunks missing moved the output into place")
+	}
+	got, err := ioutil.ReadFile(outPath + ".part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("partial output = %q, want nothing written ahead of the missing chunk", got)
+	}
+}
+
+func TestStreamAssembler(t *testing.T) {
+	dir := t.TempDir()
+	out, err := os.Create(filepath.Join(dir, "stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	m := &Manifest{path: filepath.Join(dir, ManifestFile)}
+	a, err := newStreamAssembler(testChunks(10, 10), m, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{1, 0} {
+		_, err = a.Add(chunkResult{Chunk: a.chunks[i], Data: []byte{byte('a' + i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = a.Finish()
+	if err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+
+	// the stream is left open for its owner to close
+	_, err = out.Write([]byte("!"))
+	if err != nil {
+		t.Errorf("stream was closed by Finish(): %v", err)
+	}
+	got, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ab!" {
+		t.Errorf("stream = %q, want %q", got, "ab!")
+	}
+}
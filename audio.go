@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// AudioQuality is the Quality that downloads only the audio
+const AudioQuality = "audio"
+
+// isAudioQuality reports whether a Quality asks for just the audio
+func isAudioQuality(quality string) bool {
+	return strings.EqualFold(strings.TrimSpace(quality), AudioQuality)
+}
+
+// audioOnlyGroup is the VIDEO group Twitch gives its audio-only rendition
+const audioOnlyGroup = "audio_only"
+
+// MPEG-TS stream types and PIDs used to find the audio
+const (
+	tsPIDPAT        = 0x0000
+	tsTablePAT      = 0x00
+	tsTablePMT      = 0x02
+	tsStreamTypeAAC = 0x0f
+)
+
+// pickAudioVariant returns the audio-only variant, or failing that the
+// smallest variant, whose audio is then extracted from the video
+func pickAudioVariant(variants []*Variant) (*Variant, error) {
+	for _, v := range variants {
+		if v.AudioOnly {
+			log.Printf("[pickAudioVariant] using audio-only variant %s <%s>", v, v.URI)
+			return v, nil
+		}
+	}
+
+	video := videoVariants(variants)
+	if len(video) == 0 {
+		return nil, fmt.Errorf("error: no variants to take the audio from")
+	}
+	smallest := video[0]
+	fmt.Println("No audio-only variant available, extracting the audio from the smallest variant")
+	log.Printf("[pickAudioVariant] no audio-only variant, using %s <%s>", smallest, smallest.URI)
+	return smallest, nil
+}
+
+// extractAAC returns the AAC audio of an MPEG-TS chunk as ADTS frames, which
+// can be joined across chunks into a .aac file
+func extractAAC(c Chunk, data []byte) ([]byte, error) {
+	fail := func(format string, args ...interface{}) error {
+		// the chunk arrived intact, so its layout won't change on a retry
+		return &ValidationError{Chunk: c.Name, Reason: fmt.Sprintf(format, args...), Permanent: true}
+	}
+
+	pmtPID, audioPID := -1, -1
+	var out, pes bytes.Buffer
+	// flush appends the payload of the PES packet collected so far
+	flush := func() error {
+		if pes.Len() == 0 {
+			return nil
+		}
+		p := pes.Bytes()
+		if len(p) < 9 || p[0] != 0 || p[1] != 0 || p[2] != 1 {
+			return fail("invalid PES packet header in audio stream")
+		}
+		start := 9 + int(p[8])
+		if start > len(p) {
+			return fail("truncated PES packet header in audio stream")
+		}
+		out.Write(p[start:])
+		pes.Reset()
+		return nil
+	}
+
+	for offset := 0; offset+TSPacketSize <= len(data); offset += TSPacketSize {
+		pkt := data[offset : offset+TSPacketSize]
+		unitStart := pkt[1]&0x40 != 0
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		adaptation := (pkt[3] >> 4) & 0x03
+
+		payload := pkt[4:]
+		if adaptation&0x02 != 0 {
+			n := 1 + int(payload[0])
+			if n > len(payload) {
+				return nil, fail("invalid adaptation field at offset %d", offset)
+			}
+			payload = payload[n:]
+		}
+		if adaptation&0x01 == 0 || len(payload) == 0 {
+			continue
+		}
+
+		switch {
+		case pid == tsPIDPAT && unitStart && pmtPID < 0:
+			pmtPID = parsePAT(payload)
+		case pid == pmtPID && unitStart && audioPID < 0:
+			audioPID = parsePMT(payload)
+		case pid == audioPID:
+			if unitStart {
+				err := flush()
+				if err != nil {
+					return nil, err
+				}
+			}
+			pes.Write(payload)
+		}
+	}
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+
+	if audioPID < 0 {
+		return nil, fmt.Errorf("error: chunk %s has no AAC audio track", c.Name)
+	}
+	err = checkADTS(out.Bytes())
+	if err != nil {
+		return nil, fail("%s", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// psiSection returns the table section in the payload of a packet that
+// starts one, skipping its pointer field
+func psiSection(payload []byte, tableID byte) []byte {
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) || payload[start] != tableID {
+		return nil
+	}
+	s := payload[start:]
+	end := 3 + (int(s[1]&0x0f)<<8 | int(s[2]))
+	if end < 12 || end > len(s) {
+		// sections spanning packets aren't needed for a single program
+		return nil
+	}
+	// drop the CRC
+	return s[:end-4]
+}
+
+// parsePAT returns the PMT PID of the first program, or -1
+func parsePAT(payload []byte) int {
+	s := psiSection(payload, tsTablePAT)
+	for i := 8; i+4 <= len(s); i += 4 {
+		program := int(s[i])<<8 | int(s[i+1])
+		if program != 0 {
+			return int(s[i+2]&0x1f)<<8 | int(s[i+3])
+		}
+	}
+	return -1
+}
+
+// parsePMT returns the PID of the first AAC (ADTS) stream, or -1
+func parsePMT(payload []byte) int {
+	s := psiSection(payload, tsTablePMT)
+	if len(s) < 12 {
+		return -1
+	}
+	i := 12 + (int(s[10]&0x0f)<<8 | int(s[11]))
+	for i+5 <= len(s) {
+		streamType := s[i]
+		pid := int(s[i+1]&0x1f)<<8 | int(s[i+2])
+		if streamType == tsStreamTypeAAC {
+			return pid
+		}
+		i += 5 + (int(s[i+3]&0x0f)<<8 | int(s[i+4]))
+	}
+	return -1
+}
+
+// checkADTS verifies that data is a sequence of whole ADTS frames
+func checkADTS(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("no audio in chunk")
+	}
+	for offset := 0; offset < len(data); {
+		f := data[offset:]
+		if len(f) < 7 || f[0] != 0xff || f[1]&0xf0 != 0xf0 {
+			return fmt.Errorf("missing ADTS sync word at offset %d", offset)
+		}
+		size := int(f[3]&0x03)<<11 | int(f[4])<<3 | int(f[5])>>5
+		if size < 7 || size > len(f) {
+			return fmt.Errorf("invalid ADTS frame length %d at offset %d", size, offset)
+		}
+		offset += size
+	}
+	return nil
+}
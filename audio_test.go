@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const (
+	testPMTPID   = 0x1000
+	testVideoPID = 0x0100
+	testAudioPID = 0x0101
+)
+
+// tsPacket builds a single MPEG-TS packet. af is the body of an adaptation
+// field (nil for none); one is added anyway to stuff a short payload.
+func tsPacket(pid int, unitStart bool, af []byte, payload []byte) []byte {
+	pkt := []byte{0x47, byte(pid>>8) & 0x1f, byte(pid), 0x10}
+	if unitStart {
+		pkt[1] |= 0x40
+	}
+	free := TSPacketSize - len(pkt)
+	if af != nil || len(payload) < free {
+		n := free - 1 - len(payload)
+		field := make([]byte, 1+n)
+		field[0] = byte(n)
+		copy(field[1:], af)
+		for i := 1 + len(af); i < len(field); i++ {
+			field[i] = 0xff
+		}
+		if n > 0 && af == nil {
+			// no flags set
+			field[1] = 0x00
+		}
+		pkt[3] |= 0x20
+		pkt = append(pkt, field...)
+	}
+	pkt = append(pkt, payload...)
+	if len(pkt) != TSPacketSize {
+		panic("payload doesn't fit in a TS packet")
+	}
+	return pkt
+}
+
+// tsPackets splits data into as many packets as it takes, with af in the
+// first one
+func tsPackets(pid int, af []byte, data []byte) []byte {
+	var out []byte
+	first := true
+	for first || len(data) > 0 {
+		room := TSPacketSize - 4
+		if first && af != nil {
+			room -= 1 + len(af)
+		}
+		n := len(data)
+		if n > room {
+			n = room
+		}
+		if first {
+			out = append(out, tsPacket(pid, true, af, data[:n])...)
+		} else {
+			out = append(out, tsPacket(pid, false, nil, data[:n])...)
+		}
+		data = data[n:]
+		first = false
+	}
+	return out
+}
+
+// psiPayload wraps a table section (without its CRC) in a pointer field and
+// a dummy CRC, filling the rest of the packet as an encoder would
+func psiPayload(pointer int, section []byte) []byte {
+	p := append(make([]byte, 1+pointer), section...)
+	p[0] = byte(pointer)
+	p = append(p, 0xde, 0xad, 0xbe, 0xef)
+	for len(p) < TSPacketSize-4 {
+		p = append(p, 0xff)
+	}
+	return p
+}
+
+// patSection builds a PAT listing (program number, PMT PID) pairs
+func patSection(programs ...[2]int) []byte {
+	s := []byte{tsTablePAT, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0}
+	for _, p := range programs {
+		s = append(s, byte(p[0]>>8), byte(p[0]), 0xe0|byte(p[1]>>8), byte(p[1]))
+	}
+	return setSectionLength(s)
+}
+
+type pmtStream struct {
+	streamType  byte
+	pid         int
+	descriptors []byte
+}
+
+// pmtSection builds a PMT with the given program descriptors and streams
+func pmtSection(programInfo []byte, streams ...pmtStream) []byte {
+	s := []byte{tsTablePMT, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0,
+		0xe0 | byte(testVideoPID>>8), byte(testVideoPID & 0xff),
+		0xf0 | byte(len(programInfo)>>8), byte(len(programInfo))}
+	s = append(s, programInfo...)
+	for _, st := range streams {
+		s = append(s, st.streamType, 0xe0|byte(st.pid>>8), byte(st.pid),
+			0xf0|byte(len(st.descriptors)>>8), byte(len(st.descriptors)))
+		s = append(s, st.descriptors...)
+	}
+	return setSectionLength(s)
+}
+
+// setSectionLength fills in a section's length, counting the CRC that
+// psiPayload adds
+func setSectionLength(s []byte) []byte {
+	n := len(s) - 3 + 4
+	s[1] |= byte(n>>8) & 0x0f
+	s[2] = byte(n)
+	return s
+}
+
+// adtsFrame builds an ADTS frame of size bytes with a recognizable payload
+func adtsFrame(size int, fill byte) []byte {
+	f := make([]byte, size)
+	f[0], f[1], f[2] = 0xff, 0xf1, 0x50
+	f[3] = 0x80 | byte(size>>11)&0x03
+	f[4] = byte(size >> 3)
+	f[5] = byte(size&0x07)<<5 | 0x1f
+	f[6] = 0xfc
+	for i := 7; i < size; i++ {
+		f[i] = fill
+	}
+	return f
+}
+
+// pesPacket wraps data in a PES packet header with a PTS
+func pesPacket(data []byte) []byte {
+	return append([]byte{0x00, 0x00, 0x01, 0xc0, 0x00, 0x00, 0x80, 0x80, 0x05, 0x21, 0x00, 0x01, 0x00, 0x01}, data...)
+}
+
+// tsChunk builds a chunk with a PAT, a PMT with a video and an AAC stream,
+// and then the given packets
+func tsChunk(packets ...[]byte) []byte {
+	data := tsPacket(tsPIDPAT, true, nil, psiPayload(0, patSection([2]int{1, testPMTPID})))
+	data = append(data, tsPacket(testPMTPID, true, nil, psiPayload(0, pmtSection(nil,
+		pmtStream{streamType: 0x1b, pid: testVideoPID},
+		pmtStream{streamType: tsStreamTypeAAC, pid: testAudioPID})))...)
+	for _, p := range packets {
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestExtractAAC(t *testing.T) {
+	frames := [][]byte{adtsFrame(150, 0x11), adtsFrame(150, 0x22), adtsFrame(150, 0x33), adtsFrame(40, 0x44)}
+	pcr := []byte{0x10, 0x00, 0x00, 0x00, 0x01, 0x7e, 0x00}
+
+	badAF := tsPacket(testAudioPID, true, nil, pesPacket(frames[3]))
+	badAF[3] |= 0x20
+	badAF[4] = 200
+
+	tests := []struct {
+		name       string
+		data       []byte
+		want       []byte
+		wantErr    bool
+		validation bool
+	}{
+		{
+			name: "PES packets over several TS packets",
+			data: tsChunk(
+				tsPackets(testAudioPID, nil, pesPacket(bytes.Join(frames[:3], nil))),
+				tsPackets(testAudioPID, nil, pesPacket(frames[3])),
+			),
+			want: bytes.Join(frames, nil),
+		},
+		{
+			name: "adaptation fields and interleaved video",
+			data: tsChunk(
+				tsPackets(testVideoPID, pcr, bytes.Repeat([]byte{0xaa}, 300)),
+				tsPackets(testAudioPID, pcr, pesPacket(bytes.Join(frames[:3], nil))),
+				tsPackets(testVideoPID, nil, bytes.Repeat([]byte{0xbb}, 200)),
+				tsPackets(testAudioPID, nil, pesPacket(frames[3])),
+			),
+			want: bytes.Join(frames, nil),
+		},
+		{
+			name: "audio before the PMT is ignored",
+			data: append(tsPackets(testAudioPID, nil, pesPacket(frames[0])),
+				tsChunk(tsPackets(testAudioPID, nil, pesPacket(frames[1])))...),
+			want: frames[1],
+		},
+		{
+			name: "no AAC stream",
+			data: append(tsPacket(tsPIDPAT, true, nil, psiPayload(0, patSection([2]int{1, testPMTPID}))),
+				tsPacket(testPMTPID, true, nil, psiPayload(0, pmtSection(nil, pmtStream{streamType: 0x1b, pid: testVideoPID})))...),
+			wantErr: true,
+		},
+		{
+			name:       "no audio packets",
+			data:       tsChunk(tsPackets(testVideoPID, nil, bytes.Repeat([]byte{0xaa}, 300))),
+			wantErr:    true,
+			validation: true,
+		},
+		{
+			name:       "invalid PES header",
+			data:       tsChunk(tsPackets(testAudioPID, nil, append([]byte{0x00, 0x00, 0x02}, pesPacket(frames[0])[3:]...))),
+			wantErr:    true,
+			validation: true,
+		},
+		{
+			name:       "truncated PES header",
+			data:       tsChunk(tsPackets(testAudioPID, nil, []byte{0x00, 0x00, 0x01, 0xc0, 0x00, 0x00, 0x80, 0x80, 0x20, 0x21})),
+			wantErr:    true,
+			validation: true,
+		},
+		{
+			name:       "invalid adaptation field",
+			data:       tsChunk(badAF),
+			wantErr:    true,
+			validation: true,
+		},
+		{
+			name:       "not ADTS",
+			data:       tsChunk(tsPackets(testAudioPID, nil, pesPacket(bytes.Repeat([]byte{0x55}, 100)))),
+			wantErr:    true,
+			validation: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractAAC(Chunk{Name: "0.ts"}, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractAAC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var validationErr *ValidationError
+				if errors.As(err, &validationErr) != tt.validation {
+					t.Errorf("extractAAC() error = %v, want a ValidationError: %v", err, tt.validation)
+				}
+				if isRetryable(err) {
+					t.Errorf("extractAAC() error = %v is retryable, want it permanent", err)
+				}
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("extractAAC() returned %d bytes, want %d bytes", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestParsePAT(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    int
+	}{
+		{"single program", psiPayload(0, patSection([2]int{1, testPMTPID})), testPMTPID},
+		{"network PID is skipped", psiPayload(0, patSection([2]int{0, 0x0010}, [2]int{1, 0x0020})), 0x0020},
+		{"pointer field", psiPayload(3, patSection([2]int{1, testPMTPID})), testPMTPID},
+		{"no programs", psiPayload(0, patSection()), -1},
+		{"wrong table", psiPayload(0, pmtSection(nil)), -1},
+		{"section longer than the packet", append([]byte{0, tsTablePAT, 0xb0, 0xff}, make([]byte, 20)...), -1},
+		{"pointer past the payload", []byte{0xff, 0, 0, 0}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePAT(tt.payload); got != tt.want {
+				t.Errorf("parsePAT() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePMT(t *testing.T) {
+	video := pmtStream{streamType: 0x1b, pid: testVideoPID}
+	audio := pmtStream{streamType: tsStreamTypeAAC, pid: testAudioPID}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    int
+	}{
+		{"audio after video", psiPayload(0, pmtSection(nil, video, audio)), testAudioPID},
+		{"audio first", psiPayload(0, pmtSection(nil, audio, video)), testAudioPID},
+		{"stream descriptors", psiPayload(0, pmtSection(nil,
+			pmtStream{streamType: 0x1b, pid: testVideoPID, descriptors: []byte{0x28, 0x04, 0x64, 0x00, 0x1f, 0x3f}},
+			audio)), testAudioPID},
+		{"program descriptors", psiPayload(0, pmtSection([]byte{0x05, 0x04, 'H', 'D', 'M', 'V'}, video, audio)), testAudioPID},
+		{"no AAC stream", psiPayload(0, pmtSection(nil, video, pmtStream{streamType: 0x03, pid: testAudioPID})), -1},
+		{"no streams", psiPayload(0, pmtSection(nil)), -1},
+		{"wrong table", psiPayload(0, patSection([2]int{1, testPMTPID})), -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePMT(tt.payload); got != tt.want {
+				t.Errorf("parsePMT() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckADTS(t *testing.T) {
+	frame := adtsFrame(50, 0x11)
+	noSync := adtsFrame(50, 0x11)
+	noSync[1] = 0x01
+	tooShort := adtsFrame(50, 0x11)
+	tooShort[4], tooShort[5] = 0, 0x1f
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"one frame", frame, false},
+		{"several frames", bytes.Join([][]byte{frame, adtsFrame(7, 0), adtsFrame(300, 0x22)}, nil), false},
+		{"empty", nil, true},
+		{"missing sync word", noSync, true},
+		{"missing sync word in a later frame", append(frame, noSync...), true},
+		{"truncated frame", frame[:49], true},
+		{"trailing bytes", append(frame, 0xff, 0xf1), true},
+		{"frame length shorter than the header", tooShort, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkADTS(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkADTS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
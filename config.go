@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"regexp"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
@@ -12,23 +13,62 @@ import (
 
 // Config represents a config object containing everything needed to download a VOD
 type Config struct {
-	ClientID     string
-	Quality      string
-	StartTime    string
-	StartSec     int
-	EndTime      string
-	EndSec       int
-	Length       string
-	VodID        int
-	FilePrefix   string
-	OutputFolder string
-	Workers      int
+	ClientID  string
+	Quality   string
+	StartTime string
+	StartSec  int
+	EndTime   string
+	EndSec    int
+	Length    string
+	Padding   string
+	VodID     int
+	// HLSInput is the playlist URL or path in HLS mode (command-line only)
+	HLSInput string `toml:"-"`
+	// Output is an exact output path, or "-" for stdout, used instead of
+	// FilenameTemplate (command-line only)
+	Output     string `toml:"-"`
+	FilePrefix string
+	// FilenameTemplate is a text/template for the output's path within
+	// OutputFolder (see FilenameData)
+	FilenameTemplate string
+	OutputFolder     string
+	TempFolder       string
+	Workers          WorkerCount
+	MinWorkers       int
+	MaxWorkers       int
+	MaxAttempts      int
+	LimitRate        string
+	KeepGoing        bool
+	KeepChunks       bool
+	MutedPolicy      string
+
+	Container        string
+	Trim             bool
+	TrimReencodeHead bool
+	FFmpegPath       string
+
+	IgnoreDiskSpace bool
+
+	ConnectTimeout  string
+	ReadTimeout     string
+	Timeout         string
+	UserAgent       string
+	Headers         map[string]string
+	MaxConnsPerHost int
+	Proxy           string
 }
 
-// Privatize returns a copy of the struct with the ClientID field censored (e.g. for logging)
+// Privatize returns a copy of the struct with the ClientID field and header
+// values censored (e.g. for logging)
 func (c Config) Privatize() Config {
 	c2 := c
 	c2.ClientID = "********"
+	if len(c.Headers) > 0 {
+		c2.Headers = make(map[string]string, len(c.Headers))
+		for k := range c.Headers {
+			c2.Headers[k] = "********"
+		}
+	}
 	return c2
 }
 
@@ -52,15 +92,94 @@ func (c *Config) Update(c2 Config) {
 	if c2.VodID != 0 {
 		c.VodID = c2.VodID
 	}
+	if c2.HLSInput != "" {
+		c.HLSInput = c2.HLSInput
+	}
+	if c2.Output != "" {
+		c.Output = c2.Output
+	}
 	if c2.FilePrefix != "" {
 		c.FilePrefix = c2.FilePrefix
 	}
+	if c2.FilenameTemplate != "" {
+		c.FilenameTemplate = c2.FilenameTemplate
+	}
 	if c2.OutputFolder != "" {
 		c.OutputFolder = c2.OutputFolder
 	}
+	if c2.TempFolder != "" {
+		c.TempFolder = c2.TempFolder
+	}
 	if c2.Workers != 0 {
 		c.Workers = c2.Workers
 	}
+	if c2.MinWorkers != 0 {
+		c.MinWorkers = c2.MinWorkers
+	}
+	if c2.MaxWorkers != 0 {
+		c.MaxWorkers = c2.MaxWorkers
+	}
+	if c2.MaxAttempts != 0 {
+		c.MaxAttempts = c2.MaxAttempts
+	}
+	if c2.LimitRate != "" {
+		c.LimitRate = c2.LimitRate
+	}
+	if c2.Padding != "" {
+		c.Padding = c2.Padding
+	}
+	if c2.KeepGoing {
+		c.KeepGoing = c2.KeepGoing
+	}
+	if c2.KeepChunks {
+		c.KeepChunks = c2.KeepChunks
+	}
+	if c2.Container != "" {
+		c.Container = c2.Container
+	}
+	if c2.Trim {
+		c.Trim = c2.Trim
+	}
+	if c2.TrimReencodeHead {
+		c.TrimReencodeHead = c2.TrimReencodeHead
+	}
+	if c2.FFmpegPath != "" {
+		c.FFmpegPath = c2.FFmpegPath
+	}
+	if c2.MutedPolicy != "" {
+		c.MutedPolicy = c2.MutedPolicy
+	}
+	if c2.IgnoreDiskSpace {
+		c.IgnoreDiskSpace = c2.IgnoreDiskSpace
+	}
+	if c2.ConnectTimeout != "" {
+		c.ConnectTimeout = c2.ConnectTimeout
+	}
+	if c2.ReadTimeout != "" {
+		c.ReadTimeout = c2.ReadTimeout
+	}
+	if c2.Timeout != "" {
+		c.Timeout = c2.Timeout
+	}
+	if c2.UserAgent != "" {
+		c.UserAgent = c2.UserAgent
+	}
+	if len(c2.Headers) > 0 {
+		headers := make(map[string]string, len(c.Headers)+len(c2.Headers))
+		for k, v := range c.Headers {
+			headers[k] = v
+		}
+		for k, v := range c2.Headers {
+			headers[k] = v
+		}
+		c.Headers = headers
+	}
+	if c2.MaxConnsPerHost != 0 {
+		c.MaxConnsPerHost = c2.MaxConnsPerHost
+	}
+	if c2.Proxy != "" {
+		c.Proxy = c2.Proxy
+	}
 }
 
 // Validate checks if the config object appears valid. Required attributes must
@@ -68,11 +187,14 @@ func (c *Config) Update(c2 Config) {
 //
 // Currently, "OutputFolder" is not validated (needs logic to support Windows paths)
 func (c Config) Validate() error {
-	if len(c.ClientID) == 0 {
+	// HLS mode doesn't talk to Twitch
+	twitch := c.HLSInput == ""
+
+	if twitch && len(c.ClientID) == 0 {
 		return fmt.Errorf("error: ClientID missing")
 	}
 
-	if c.VodID < 1 {
+	if twitch && c.VodID < 1 {
 		return fmt.Errorf("error: VodID missing")
 	}
 
@@ -91,18 +213,102 @@ func (c Config) Validate() error {
 		return fmt.Errorf("error: Length must be 'full' or in format '%s'; got '%s'", timePattern, c.Length)
 	}
 
-	qualityPattern := `\d{3,4}p[36]0`
-	qualityRegex := regexp.MustCompile(qualityPattern)
-	if c.Quality != "best" && c.Quality != "chunked" && !qualityRegex.MatchString(c.Quality) {
-		return fmt.Errorf("error: Quality must be 'best', 'chunked', or in format '%s'; got '%s'", qualityPattern, c.Quality)
+	// which names exist depends on the stream, so unknown ones are left for
+	// pickVariant to report along with the ones that are available
+	prefs := splitQuality(c.Quality)
+	if len(prefs) == 0 {
+		return errors.New("error: Quality missing")
+	}
+	for _, q := range prefs {
+		if isAudioQuality(q) && len(prefs) > 1 {
+			return fmt.Errorf("error: Quality '%s' can't be combined with other qualities; got '%s'", AudioQuality, c.Quality)
+		}
+		if strings.HasPrefix(q, "<=") && !maxHeightRegex.MatchString(strings.ToLower(q)) {
+			return fmt.Errorf("error: Quality limit must be in format '<=720p'; got '%s'", q)
+		}
 	}
 
 	if c.FilePrefix != "" && !isValidFilename(c.FilePrefix) {
 		return fmt.Errorf("error: FilePrefix contains invalid characters; got '%s'", c.FilePrefix)
 	}
+	if c.FilenameTemplate == "" {
+		return errors.New("error: FilenameTemplate missing")
+	}
+	// catch unknown fields and syntax errors before downloading anything
+	_, err := renderFilename(c.FilenameTemplate, FilenameData{VodID: "0", Start: "00h00m00s", End: "00h00m00s", Ext: ContainerTS})
+	if err != nil {
+		return err
+	}
+
+	if c.Workers < 1 && c.Workers != AutoWorkers {
+		return fmt.Errorf("error: Worker must be 'auto' or an integer greater than 0; got '%s'", c.Workers)
+	}
+	if c.Workers == AutoWorkers && (c.MinWorkers < 1 || c.MaxWorkers < c.MinWorkers) {
+		return fmt.Errorf("error: MinWorkers must be greater than 0 and no more than MaxWorkers; got %d and %d", c.MinWorkers, c.MaxWorkers)
+	}
+
+	if isAudioQuality(c.Quality) {
+		switch c.Container {
+		case ContainerAuto, ContainerAAC, ContainerM4A:
+		default:
+			return fmt.Errorf("error: Container must be '%s', '%s', or '%s' for audio; got '%s'", ContainerAuto, ContainerAAC, ContainerM4A, c.Container)
+		}
+	} else {
+		switch c.Container {
+		case ContainerAuto, ContainerTS, ContainerMP4, ContainerMKV:
+		default:
+			return fmt.Errorf("error: Container must be '%s', '%s', '%s', or '%s'; got '%s'", ContainerAuto, ContainerTS, ContainerMP4, ContainerMKV, c.Container)
+		}
+	}
+
+	if c.TrimReencodeHead && !c.Trim {
+		return errors.New("error: TrimReencodeHead requires Trim")
+	}
+	if c.TrimReencodeHead && isAudioQuality(c.Quality) {
+		return errors.New("error: TrimReencodeHead only applies to video")
+	}
+
+	// the stream can only be written to stdout as it is
+	if c.Output == OutputStdout && c.Trim {
+		return errors.New("error: Trim can't be used when writing to stdout")
+	}
+	if c.Output == OutputStdout && c.Container != ContainerAuto {
+		return fmt.Errorf("error: Container must be '%s' when writing to stdout; got '%s'", ContainerAuto, c.Container)
+	}
+
+	switch c.MutedPolicy {
+	case MutedKeep, MutedUnmute, MutedSkip:
+	default:
+		return fmt.Errorf("error: MutedPolicy must be '%s', '%s', or '%s'; got '%s'", MutedKeep, MutedUnmute, MutedSkip, c.MutedPolicy)
+	}
 
-	if c.Workers < 1 {
-		return fmt.Errorf("error: Worker must be an integer greater than 0; got '%d'", c.Workers)
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("error: MaxAttempts must be an integer greater than 0; got '%d'", c.MaxAttempts)
+	}
+
+	if c.LimitRate != "" {
+		_, err := parseRate(c.LimitRate)
+		if err != nil {
+			return fmt.Errorf("error: LimitRate is invalid: %w", err)
+		}
+	}
+
+	for name, d := range map[string]string{"ConnectTimeout": c.ConnectTimeout, "ReadTimeout": c.ReadTimeout, "Timeout": c.Timeout, "Padding": c.Padding} {
+		_, err := parseOptionalDuration(d)
+		if err != nil {
+			return fmt.Errorf("error: %s must be a duration such as '30s'; got '%s'", name, d)
+		}
+	}
+
+	if c.MaxConnsPerHost < 0 {
+		return fmt.Errorf("error: MaxConnsPerHost must not be negative; got '%d'", c.MaxConnsPerHost)
+	}
+
+	if c.Proxy != "" {
+		_, err := parseProxy(c.Proxy)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -181,15 +387,94 @@ func buildConfigFromFlags() (Config, error) {
 	if *prefix != "" {
 		config.FilePrefix = *prefix
 	}
+	if *filenameTemplate != "" {
+		config.FilenameTemplate = *filenameTemplate
+	}
 	if *folder != "" {
 		config.OutputFolder = *folder
 	}
-	if *workers != 0 {
-		config.Workers = *workers
+	if *tempDir != "" {
+		config.TempFolder = *tempDir
+	}
+	if *workers != "" {
+		w, err := parseWorkerCount(*workers)
+		if err != nil {
+			return config, err
+		}
+		config.Workers = w
+	}
+	if *attempts != 0 {
+		config.MaxAttempts = *attempts
+	}
+	if *limitRate != "" {
+		config.LimitRate = *limitRate
+	}
+	if *padding != "" {
+		config.Padding = *padding
+	}
+	if *keepGoing {
+		config.KeepGoing = *keepGoing
+	}
+	if *keepChunks {
+		config.KeepChunks = *keepChunks
+	}
+	if *container != "" {
+		config.Container = *container
+	}
+	if *trim {
+		config.Trim = *trim
+	}
+	if *reencodeHead {
+		config.TrimReencodeHead = *reencodeHead
+	}
+	if *ffmpegPath != "" {
+		config.FFmpegPath = *ffmpegPath
+	}
+	if *mutedPolicy != "" {
+		config.MutedPolicy = *mutedPolicy
+	}
+	if *ignoreDiskSpace {
+		config.IgnoreDiskSpace = *ignoreDiskSpace
+	}
+	if *connectTimeout != "" {
+		config.ConnectTimeout = *connectTimeout
+	}
+	if *readTimeout != "" {
+		config.ReadTimeout = *readTimeout
+	}
+	if *timeout != "" {
+		config.Timeout = *timeout
+	}
+	if *userAgent != "" {
+		config.UserAgent = *userAgent
+	}
+	if len(*headers) > 0 {
+		config.Headers = make(map[string]string, len(*headers))
+		for _, h := range *headers {
+			k, v, err := parseHeader(h)
+			if err != nil {
+				return config, err
+			}
+			config.Headers[k] = v
+		}
+	}
+	if *maxConnsPerHost != 0 {
+		config.MaxConnsPerHost = *maxConnsPerHost
+	}
+	if *proxy != "" {
+		config.Proxy = *proxy
 	}
 	if *vodID != 0 {
 		config.VodID = *vodID
 	}
+	if *formatsVod != 0 {
+		config.VodID = *formatsVod
+	}
+	if *infoVod != 0 {
+		config.VodID = *infoVod
+	}
+	config.HLSInput = *hlsInput
+	config.Output = *outFile
 
 	return config, nil
 }
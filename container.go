@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Output containers
+const (
+	// ContainerAuto writes the chunks as they are: MPEG-TS chunks to a .ts
+	// file and fMP4 chunks to an .mp4 file
+	ContainerAuto = "auto"
+	ContainerTS   = "ts"
+	ContainerMP4  = "mp4"
+	ContainerMKV  = "mkv"
+	// ContainerAAC and ContainerM4A are for audio-only downloads
+	ContainerAAC = "aac"
+	ContainerM4A = "m4a"
+)
+
+// nativeContainer returns the container the chunks make up when they're
+// simply joined, which needs no remuxing. For audio-only downloads, the audio
+// of MPEG-TS chunks is extracted as it's downloaded, making an ADTS file.
+func nativeContainer(chunks []Chunk, audio bool) string {
+	for _, c := range chunks {
+		if isFMP4(c) {
+			return ContainerMP4
+		}
+	}
+	if audio {
+		return ContainerAAC
+	}
+	return ContainerTS
+}
+
+// pathContainer returns the container an output path's extension asks for, or
+// ContainerAuto if it's not one tvd can write
+func pathContainer(path string, audio bool) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if audio {
+		switch ext {
+		case ContainerAAC:
+			return ContainerAAC
+		case ContainerM4A, ContainerMP4:
+			return ContainerM4A
+		}
+		return ContainerAuto
+	}
+	switch ext {
+	case ContainerTS, ContainerMP4, ContainerMKV:
+		return ext
+	case "m4v":
+		return ContainerMP4
+	}
+	return ContainerAuto
+}
+
+// chooseContainer returns the container to write the output as: the one asked
+// for, the one an exact output path's extension asks for, or else the one the
+// chunks make up (native)
+func chooseContainer(cfg Config, native string, audio bool) (string, error) {
+	container := cfg.Container
+	toStdout := cfg.Output == OutputStdout
+	if cfg.Output != "" && !toStdout {
+		// an exact output path picks the container by its extension, which
+		// has to agree with one that was asked for
+		pc := pathContainer(cfg.Output, audio)
+		if container == ContainerAuto {
+			container = pc
+		} else if pc != ContainerAuto && pc != container {
+			return "", fmt.Errorf("error: the extension of <%s> asks for %s but Container is '%s'", cfg.Output, pc, container)
+		}
+	}
+	if container == ContainerAuto {
+		container = native
+		if audio && native == ContainerMP4 {
+			// the audio of fMP4 chunks is taken out by ffmpeg
+			container = ContainerM4A
+		}
+	}
+	if toStdout && container != native {
+		return "", fmt.Errorf("error: the stream (%s) can't be converted to %s when writing to stdout", native, container)
+	}
+	return container, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// testSegments builds chunks with the given segment file names
+func testSegments(names ...string) []Chunk {
+	chunks := make([]Chunk, len(names))
+	for i, n := range names {
+		chunks[i] = Chunk{Index: i, Name: n, URL: &url.URL{Scheme: "https", Host: "cdn.example.com", Path: "/vod/" + n}}
+	}
+	return chunks
+}
+
+func TestNativeContainer(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []Chunk
+		audio  bool
+		want   string
+	}{
+		{"MPEG-TS", testSegments("0.ts", "1.ts"), false, ContainerTS},
+		{"MPEG-TS audio", testSegments("0.ts", "1.ts"), true, ContainerAAC},
+		{"fMP4", testSegments("0.m4s", "1.m4s"), false, ContainerMP4},
+		{"fMP4 audio", testSegments("0.m4s", "1.m4s"), true, ContainerMP4},
+		{"CMAF", testSegments("0.cmfv"), false, ContainerMP4},
+		{"upper case extension", testSegments("0.M4S"), false, ContainerMP4},
+		{"no extension", testSegments("0", "1"), false, ContainerTS},
+		{"no chunks", nil, false, ContainerTS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nativeContainer(tt.chunks, tt.audio); got != tt.want {
+				t.Errorf("nativeContainer() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathContainer(t *testing.T) {
+	tests := []struct {
+		path  string
+		audio bool
+		want  string
+	}{
+		{"out.ts", false, ContainerTS},
+		{"out.mp4", false, ContainerMP4},
+		{"out.MP4", false, ContainerMP4},
+		{"out.m4v", false, ContainerMP4},
+		{"out.mkv", false, ContainerMKV},
+		{"out.aac", false, ContainerAuto},
+		{"out.avi", false, ContainerAuto},
+		{"out", false, ContainerAuto},
+		{"dir.mp4/out", false, ContainerAuto},
+		{"out.aac", true, ContainerAAC},
+		{"out.m4a", true, ContainerM4A},
+		{"out.mp4", true, ContainerM4A},
+		{"out.ts", true, ContainerAuto},
+		{"out.mkv", true, ContainerAuto},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := pathContainer(tt.path, tt.audio); got != tt.want {
+				t.Errorf("pathContainer(%q, %v) = %s, want %s", tt.path, tt.audio, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChooseContainer(t *testing.T) {
+	tests := []struct {
+		name      string
+		container string
+		output    string
+		native    string
+		audio     bool
+		want      string
+		wantErr   bool
+	}{
+		{"auto", ContainerAuto, "", ContainerTS, false, ContainerTS, false},
+		{"auto fMP4", ContainerAuto, "", ContainerMP4, false, ContainerMP4, false},
+		{"asked for", ContainerMKV, "", ContainerTS, false, ContainerMKV, false},
+		{"output extension", ContainerAuto, "out.mp4", ContainerTS, false, ContainerMP4, false},
+		{"output extension agrees", ContainerMP4, "out.mp4", ContainerTS, false, ContainerMP4, false},
+		{"output extension disagrees", ContainerMKV, "out.mp4", ContainerTS, false, "", true},
+		{"unknown output extension", ContainerMKV, "out.video", ContainerTS, false, ContainerMKV, false},
+		{"unknown output extension, auto", ContainerAuto, "out.video", ContainerTS, false, ContainerTS, false},
+		{"audio from MPEG-TS", ContainerAuto, "", ContainerAAC, true, ContainerAAC, false},
+		{"audio from fMP4", ContainerAuto, "", ContainerMP4, true, ContainerM4A, false},
+		{"audio to m4a", ContainerAuto, "out.m4a", ContainerAAC, true, ContainerM4A, false},
+		{"stdout", ContainerAuto, OutputStdout, ContainerTS, false, ContainerTS, false},
+		{"stdout with a conversion", ContainerMP4, OutputStdout, ContainerTS, false, "", true},
+		{"stdout audio from fMP4", ContainerAuto, OutputStdout, ContainerMP4, true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Container: tt.container, Output: tt.output}
+			got, err := chooseContainer(cfg, tt.native, tt.audio)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chooseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("chooseContainer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/grafov/m3u8"
+)
+
+// ChunkKey is the AES-128 key info for an encrypted chunk (EXT-X-KEY)
+type ChunkKey struct {
+	URI *url.URL
+	// IV is the explicit IV from the tag, or nil to use the media sequence
+	// number
+	IV []byte
+}
+
+// parseChunkKey converts an EXT-X-KEY tag into a ChunkKey, resolving its URI
+// against the playlist URL. It returns nil for METHOD=NONE.
+func parseChunkKey(k *m3u8.Key, baseURL *url.URL) (*ChunkKey, error) {
+	switch strings.ToUpper(k.Method) {
+	case "", "NONE":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("error: unsupported encryption method '%s'", k.Method)
+	}
+
+	if k.URI == "" {
+		return nil, fmt.Errorf("error: invalid key URI '%s'", k.URI)
+	}
+	keyURL, err := resolveURI(baseURL, k.URI)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid key URI '%s': %w", k.URI, err)
+	}
+	ck := &ChunkKey{URI: keyURL}
+
+	if k.IV != "" {
+		iv := strings.TrimPrefix(strings.TrimPrefix(k.IV, "0x"), "0X")
+		// the IV is a 128-bit hex integer, which may have been written without
+		// leading zeros
+		if len(iv) < 2*aes.BlockSize {
+			iv = strings.Repeat("0", 2*aes.BlockSize-len(iv)) + iv
+		}
+		ck.IV, err = hex.DecodeString(iv)
+		if err != nil || len(ck.IV) != aes.BlockSize {
+			return nil, fmt.Errorf("error: invalid IV '%s'", k.IV)
+		}
+	}
+
+	return ck, nil
+}
+
+// IVFor returns the IV for a chunk with the given media sequence number
+func (k *ChunkKey) IVFor(seqNo uint64) []byte {
+	if k.IV != nil {
+		return k.IV
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], seqNo)
+	return iv
+}
+
+// keyCache fetches each key URI once and shares it across workers
+type keyCache struct {
+	client *http.Client
+	mu     sync.Mutex
+	keys   map[string][]byte
+}
+
+func newKeyCache(client *http.Client) *keyCache {
+	return &keyCache{client: client, keys: make(map[string][]byte)}
+}
+
+// Get returns the key at u, fetching it if it isn't cached yet. Failed
+// fetches aren't cached so that the chunk's retry can try again.
+func (kc *keyCache) Get(ctx context.Context, u *url.URL) ([]byte, error) {
+	kc.mu.Lock()
+	key, ok := kc.keys[u.String()]
+	kc.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	log.Printf("[keyCache] fetching key <%s>", u)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := kc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return nil, &HTTPStatusError{URL: u.String(), StatusCode: rsp.StatusCode}
+	}
+	key, err = ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("error: key <%s> is %d bytes, expected %d", u, len(key), aes.BlockSize)
+	}
+
+	kc.mu.Lock()
+	kc.keys[u.String()] = key
+	kc.mu.Unlock()
+
+	return key, nil
+}
+
+// decryptChunk decrypts an AES-128-CBC chunk and strips its PKCS#7 padding
+func decryptChunk(c Chunk, key, data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, &ValidationError{Chunk: c.Name, Reason: fmt.Sprintf("encrypted payload of %d bytes is not a multiple of the AES block size", len(data))}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, c.Key.IVFor(c.SeqNo)).CryptBlocks(out, data)
+
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, &ValidationError{Chunk: c.Name, Reason: "invalid padding after decryption (wrong key or IV?)", Permanent: true}
+	}
+
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestParseChunkKey(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/vod/index.m3u8")
+
+	tests := []struct {
+		name    string
+		key     m3u8.Key
+		wantNil bool
+		wantURI string
+		wantIV  string
+		wantErr bool
+	}{
+		{"no method", m3u8.Key{}, true, "", "", false},
+		{"NONE", m3u8.Key{Method: "NONE"}, true, "", "", false},
+		{"media sequence IV", m3u8.Key{Method: "AES-128", URI: "key.bin"}, false, "https://cdn.example.com/vod/key.bin", "", false},
+		{"lower case method", m3u8.Key{Method: "aes-128", URI: "key.bin"}, false, "https://cdn.example.com/vod/key.bin", "", false},
+		{"explicit IV", m3u8.Key{Method: "AES-128", URI: "key.bin", IV: "0x000102030405060708090a0b0c0d0e0f"}, false, "https://cdn.example.com/vod/key.bin", "000102030405060708090a0b0c0d0e0f", false},
+		{"upper case IV prefix", m3u8.Key{Method: "AES-128", URI: "key.bin", IV: "0X0F"}, false, "https://cdn.example.com/vod/key.bin", "0000000000000000000000000000000f", false},
+		{"IV without leading zeros", m3u8.Key{Method: "AES-128", URI: "key.bin", IV: "0x1"}, false, "https://cdn.example.com/vod/key.bin", "00000000000000000000000000000001", false},
+		{"IV too long", m3u8.Key{Method: "AES-128", URI: "key.bin", IV: "0x" + "00112233445566778899aabbccddeeff00"}, false, "", "", true},
+		{"IV not hex", m3u8.Key{Method: "AES-128", URI: "key.bin", IV: "0xnothex"}, false, "", "", true},
+		{"SAMPLE-AES", m3u8.Key{Method: "SAMPLE-AES", URI: "key.bin"}, false, "", "", true},
+		{"no URI", m3u8.Key{Method: "AES-128"}, false, "", "", true},
+		{"local key in a remote playlist", m3u8.Key{Method: "AES-128", URI: "file:///etc/key.bin"}, false, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChunkKey(&tt.key, base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChunkKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("parseChunkKey() = %+v, want nil: %v", got, tt.wantNil)
+			}
+			if got == nil {
+				return
+			}
+			if got.URI.String() != tt.wantURI {
+				t.Errorf("parseChunkKey() URI = %s, want %s", got.URI, tt.wantURI)
+			}
+			if hex.EncodeToString(got.IV) != tt.wantIV {
+				t.Errorf("parseChunkKey() IV = %x, want %s", got.IV, tt.wantIV)
+			}
+		})
+	}
+}
+
+func TestChunkKeyIVFor(t *testing.T) {
+	explicit := bytes.Repeat([]byte{0xab}, aes.BlockSize)
+
+	tests := []struct {
+		name  string
+		key   ChunkKey
+		seqNo uint64
+		want  string
+	}{
+		{"first chunk", ChunkKey{}, 0, "00000000000000000000000000000000"},
+		{"media sequence", ChunkKey{}, 1234, "000000000000000000000000000004d2"},
+		{"large media sequence", ChunkKey{}, 1 << 40, "00000000000000000000010000000000"},
+		{"explicit IV", ChunkKey{IV: explicit}, 1234, hex.EncodeToString(explicit)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tt.key.IVFor(tt.seqNo)); got != tt.want {
+				t.Errorf("IVFor(%d) = %s, want %s", tt.seqNo, got, tt.want)
+			}
+		})
+	}
+}
+
+// encryptChunk encrypts data as an AES-128 HLS segment with PKCS#7 padding
+func encryptChunk(t *testing.T, key, iv, data []byte) []byte {
+	t.Helper()
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+	return out
+}
+
+func TestDecryptChunk(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	otherKey := []byte("fedcba9876543210")
+	data := append([]byte{TSSyncByte}, bytes.Repeat([]byte{0xff}, TSPacketSize-1)...)
+	seqKey := &ChunkKey{}
+	seqIV := seqKey.IVFor(42)
+	encrypted := encryptChunk(t, key, seqIV, data)
+
+	tests := []struct {
+		name          string
+		chunk         Chunk
+		key           []byte
+		data          []byte
+		want          []byte
+		wantErr       bool
+		wantRetryable bool
+	}{
+		{"media sequence IV", Chunk{Name: "42.ts", SeqNo: 42, Key: seqKey}, key, encrypted, data, false, false},
+		{"explicit IV", Chunk{Name: "42.ts", SeqNo: 7, Key: &ChunkKey{IV: seqIV}}, key, encrypted, data, false, false},
+		{"block-sized payload", Chunk{Name: "0.ts", Key: seqKey}, key, encryptChunk(t, key, seqKey.IVFor(0), data[:aes.BlockSize]), data[:aes.BlockSize], false, false},
+		{"wrong key", Chunk{Name: "42.ts", SeqNo: 42, Key: seqKey}, otherKey, encrypted, nil, true, false},
+		{"cut short", Chunk{Name: "42.ts", SeqNo: 42, Key: seqKey}, key, encrypted[:len(encrypted)-1], nil, true, true},
+		{"empty", Chunk{Name: "42.ts", SeqNo: 42, Key: seqKey}, key, nil, nil, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptChunk(tt.chunk, tt.key, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decryptChunk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if isRetryable(err) != tt.wantRetryable {
+					t.Errorf("decryptChunk() error = %v, retryable %v, want %v", err, isRetryable(err), tt.wantRetryable)
+				}
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("decryptChunk() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/key.bin":
+			_, _ = w.Write(key)
+		case "/short.bin":
+			_, _ = w.Write(key[:8])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	kc := newKeyCache(srv.Client())
+	get := func(path string) ([]byte, error) {
+		u, _ := url.Parse(srv.URL + path)
+		return kc.Get(context.Background(), u)
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := get("/key.bin")
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("Get() = %x, %v, want %x", got, err, key)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("key was fetched %d times, want once", n)
+	}
+
+	_, err := get("/short.bin")
+	if err == nil {
+		t.Errorf("Get() of a short key succeeded")
+	}
+
+	// a failure isn't cached, so a retry fetches it again
+	var statusErr *HTTPStatusError
+	for i := 0; i < 2; i++ {
+		_, err = get("/missing.bin")
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+			t.Errorf("Get() of a missing key error = %v, want HTTP 404", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("made %d requests, want 4", n)
+	}
+}
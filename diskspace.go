@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// spaceRequirement is an amount of disk space needed in a directory
+type spaceRequirement struct {
+	Name  string
+	Dir   string
+	Bytes uint64
+}
+
+// estimateSize estimates the size of a download from a variant's bandwidth
+// (in bits per second) and the duration in seconds
+func estimateSize(bandwidth uint32, seconds float64) uint64 {
+	return uint64(float64(bandwidth) / 8 * seconds)
+}
+
+// downloadPlan describes what a download will write, for working out the
+// space it needs
+type downloadPlan struct {
+	// Bandwidth is the variant's bandwidth in bits per second
+	Bandwidth uint32
+	// Remaining is the duration in seconds of the chunks left to download
+	Remaining float64
+	// Clip is the duration in seconds of the final output
+	Clip       float64
+	Chunks     int
+	KeepChunks bool
+	// Remux is set when the download goes in the work dir and is remuxed to
+	// the output
+	Remux bool
+	// PostProcess is set when ffmpeg writes the output from the download
+	PostProcess bool
+	WorkDir     string
+	// OutDir is empty when the output goes to stdout
+	OutDir string
+}
+
+// manifestLineSize is a generous estimate of a manifest entry's size
+const manifestLineSize = 256
+
+// requirements returns the space needed in the work dir and output folder
+func (p downloadPlan) requirements() []spaceRequirement {
+	estimate := estimateSize(p.Bandwidth, p.Remaining)
+	tempNeeded := uint64(p.Chunks) * manifestLineSize
+	if p.KeepChunks {
+		tempNeeded += estimate
+	}
+	outNeeded := estimate
+	switch {
+	case p.Remux:
+		tempNeeded += estimate
+		outNeeded = estimateSize(p.Bandwidth, p.Clip)
+	case p.PostProcess:
+		// ffmpeg writes a second copy before the download is removed
+		outNeeded += estimateSize(p.Bandwidth, p.Clip)
+	}
+
+	reqs := []spaceRequirement{{Name: "temp dir", Dir: p.WorkDir, Bytes: tempNeeded}}
+	if p.OutDir != "" {
+		reqs = append(reqs, spaceRequirement{Name: "output folder", Dir: p.OutDir, Bytes: outNeeded})
+	}
+	return reqs
+}
+
+// checkDiskSpace makes sure each directory has room for what will be written
+// to it. If warnOnly is set, a shortfall is reported but not returned as an
+// error.
+func checkDiskSpace(reqs []spaceRequirement, warnOnly bool) error {
+	for _, r := range reqs {
+		free, err := freeSpace(r.Dir)
+		if err != nil {
+			// not being able to check shouldn't stop the download
+			log.Printf("[checkDiskSpace] unable to check free space in <%s>: %s", r.Dir, err)
+			continue
+		}
+		log.Printf("[checkDiskSpace] %s <%s>: need ~%d bytes, %d bytes free", r.Name, r.Dir, r.Bytes, free)
+
+		if free >= r.Bytes {
+			continue
+		}
+		msg := fmt.Sprintf("not enough free space in %s <%s>: need about %s, only %s available",
+			r.Name, r.Dir, formatBytes(int64(r.Bytes)), formatBytes(int64(free)))
+		if !warnOnly {
+			return fmt.Errorf("error: %s (use --ignore-disk-space to download anyway)", msg)
+		}
+		fmt.Printf("Warning: %s\n", msg)
+		log.Println("warning: " + msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEstimateSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		bandwidth uint32
+		seconds   float64
+		want      uint64
+	}{
+		{"one second", 8000, 1, 1000},
+		{"an hour of 1080p60", 8000000, 3600, 3600000000},
+		{"fraction of a second", 8000, 0.5, 500},
+		{"nothing left", 8000000, 0, 0},
+		{"no bandwidth", 0, 3600, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateSize(tt.bandwidth, tt.seconds); got != tt.want {
+				t.Errorf("estimateSize(%d, %v) = %d, want %d", tt.bandwidth, tt.seconds, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadPlanRequirements(t *testing.T) {
+	// 1000 bytes a second, with 100s left to download of a 60s clip
+	base := downloadPlan{Bandwidth: 8000, Remaining: 100, Clip: 60, Chunks: 10, WorkDir: "work", OutDir: "out"}
+	manifest := uint64(10 * manifestLineSize)
+
+	tests := []struct {
+		name     string
+		modify   func(p *downloadPlan)
+		wantTemp uint64
+		wantOut  uint64
+	}{
+		{"straight to the output", func(p *downloadPlan) {}, manifest, 100000},
+		{"keeping chunks", func(p *downloadPlan) { p.KeepChunks = true }, manifest + 100000, 100000},
+		{"remuxed from the work dir", func(p *downloadPlan) { p.Remux = true; p.PostProcess = true }, manifest + 100000, 60000},
+		{"remuxed and keeping chunks", func(p *downloadPlan) { p.Remux = true; p.KeepChunks = true }, manifest + 200000, 60000},
+		{"trimmed next to the download", func(p *downloadPlan) { p.PostProcess = true }, manifest, 160000},
+		{"resumed near the end", func(p *downloadPlan) { p.Remaining = 5 }, manifest, 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := base
+			tt.modify(&p)
+			want := []spaceRequirement{
+				{Name: "temp dir", Dir: "work", Bytes: tt.wantTemp},
+				{Name: "output folder", Dir: "out", Bytes: tt.wantOut},
+			}
+			if got := p.requirements(); !reflect.DeepEqual(got, want) {
+				t.Errorf("requirements() = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	t.Run("to stdout", func(t *testing.T) {
+		p := base
+		p.OutDir = ""
+		want := []spaceRequirement{{Name: "temp dir", Dir: "work", Bytes: manifest}}
+		if got := p.requirements(); !reflect.DeepEqual(got, want) {
+			t.Errorf("requirements() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		req      spaceRequirement
+		warnOnly bool
+		wantErr  bool
+	}{
+		{"room to spare", spaceRequirement{Name: "temp dir", Dir: dir, Bytes: 1}, false, false},
+		{"not enough room", spaceRequirement{Name: "temp dir", Dir: dir, Bytes: math.MaxInt64}, false, true},
+		{"not enough room, warning only", spaceRequirement{Name: "temp dir", Dir: dir, Bytes: math.MaxInt64}, true, false},
+		{"unable to check", spaceRequirement{Name: "temp dir", Dir: filepath.Join(dir, "missing"), Bytes: math.MaxInt64}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDiskSpace([]spaceRequirement{tt.req}, tt.warnOnly)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDiskSpace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ChunkError describes a chunk that could not be downloaded
+type ChunkError struct {
+	Index    int
+	Name     string
+	URL      string
+	Attempts int
+	Err      error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk #%d %s (%s) failed after %d attempt(s): %s", e.Index, e.Name, e.URL, e.Attempts, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+func newChunkError(res chunkResult) *ChunkError {
+	return &ChunkError{
+		Index:    res.Chunk.Index,
+		Name:     res.Chunk.Name,
+		URL:      res.Chunk.URL.String(),
+		Attempts: res.Attempts,
+		Err:      res.Err,
+	}
+}
+
+// ChunkErrors is returned when one or more chunks could not be downloaded
+type ChunkErrors []*ChunkError
+
+func (e ChunkErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("error: %d chunk(s) failed to download:", len(e)))
+	for _, ce := range e {
+		lines = append(lines, "  "+ce.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Sort orders the errors by chunk index
+func (e ChunkErrors) Sort() {
+	sort.Slice(e, func(i, j int) bool { return e[i].Index < e[j].Index })
+}
+
+// Gap is a chunk left out of the output because it could not be downloaded
+type Gap struct {
+	Index int
+	Name  string
+	// Start is the gap's offset in seconds from the start of the output
+	Start  float64
+	Length float64
+	Error  string
+}
+
+// writeGapReport prints the gaps in an output file and saves them next to it
+// as <outFile>.gaps.json, unless the output went to stdout
+func writeGapReport(outFile string, gaps []Gap) error {
+	fmt.Printf("Warning: %d chunk(s) could not be downloaded and were left out of the output:\n", len(gaps))
+	for _, g := range gaps {
+		line := fmt.Sprintf("  %s (+%.1fs) chunk #%d %s: %s", secondsToTimeMask(int(g.Start)), g.Length, g.Index, g.Name, g.Error)
+		fmt.Println(line)
+		log.Println("gap:" + line)
+	}
+
+	if outFile == OutputStdout {
+		// there's no output file to put the report next to
+		return nil
+	}
+
+	data, err := json.MarshalIndent(gaps, "", "  ")
+	if err != nil {
+		return err
+	}
+	reportFile := outFile + ".gaps.json"
+	fmt.Printf("Gap report saved to %s\n", reportFile)
+	return ioutil.WriteFile(reportFile, data, 0644)
+}
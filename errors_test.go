@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestChunkErrors(t *testing.T) {
+	u, _ := url.Parse("https://example.com/vod/3.ts")
+	notFound := &HTTPStatusError{URL: u.String(), StatusCode: 404}
+
+	tests := []struct {
+		name string
+		errs ChunkErrors
+		want string
+	}{
+		{
+			name: "one chunk",
+			errs: ChunkErrors{
+				newChunkError(chunkResult{Chunk: Chunk{Index: 3, Name: "3.ts", URL: u}, Attempts: 1, Err: notFound}),
+			},
+			want: "error: 1 chunk(s) failed to download:\n" +
+				"  chunk #3 3.ts (https://example.com/vod/3.ts) failed after 1 attempt(s): error: got HTTP 404 Not Found for <https://example.com/vod/3.ts>",
+		},
+		{
+			name: "sorted by index",
+			errs: ChunkErrors{
+				{Index: 7, Name: "7.ts", URL: "https://example.com/vod/7.ts", Attempts: 5, Err: errors.New("error: timeout")},
+				{Index: 2, Name: "2.ts", URL: "https://example.com/vod/2.ts", Attempts: 5, Err: errors.New("error: reset")},
+			},
+			want: "error: 2 chunk(s) failed to download:\n" +
+				"  chunk #2 2.ts (https://example.com/vod/2.ts) failed after 5 attempt(s): error: reset\n" +
+				"  chunk #7 7.ts (https://example.com/vod/7.ts) failed after 5 attempt(s): error: timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.errs.Sort()
+			if got := tt.errs.Error(); got != tt.want {
+				t.Errorf("Error() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkErrorUnwrap(t *testing.T) {
+	u, _ := url.Parse("https://example.com/vod/0.ts")
+	err := newChunkError(chunkResult{Chunk: Chunk{Name: "0.ts", URL: u}, Attempts: 2, Err: &HTTPStatusError{URL: u.String(), StatusCode: 403}})
+
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 403 {
+		t.Errorf("errors.As(%v) did not find the HTTP 403", err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultFFmpeg is the ffmpeg binary used when FFmpegPath isn't set
+const DefaultFFmpeg = "ffmpeg"
+
+// ffmpeg runs a local ffmpeg binary (and the ffprobe next to it)
+type ffmpeg struct {
+	path string
+}
+
+// newFFmpeg finds the ffmpeg binary at path, or in PATH if path is just a
+// name
+func newFFmpeg(path string) (*ffmpeg, error) {
+	if path == "" {
+		path = DefaultFFmpeg
+	}
+	p, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("error: ffmpeg not found at '%s'; install ffmpeg or set FFmpegPath (--ffmpeg) to its location: %w", path, err)
+	}
+	log.Printf("[newFFmpeg] using %s", p)
+	return &ffmpeg{path: p}, nil
+}
+
+// probePath returns the ffprobe binary that ships with ffmpeg
+func (f *ffmpeg) probePath() (string, error) {
+	name := "ffprobe" + filepath.Ext(f.path)
+	p := filepath.Join(filepath.Dir(f.path), name)
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("error: ffprobe not found next to %s or in PATH: %w", f.path, err)
+	}
+	return p, nil
+}
+
+// run runs ffmpeg with args, returning its output in the error if it fails
+func (f *ffmpeg) run(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}, args...)
+	log.Printf("[ffmpeg] %s %s", f.path, strings.Join(args, " "))
+	out, err := exec.CommandContext(ctx, f.path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error: ffmpeg failed: %w\n%s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// probe runs ffprobe with args and returns its output
+func (f *ffmpeg) probe(ctx context.Context, args ...string) ([]byte, error) {
+	p, err := f.probePath()
+	if err != nil {
+		return nil, err
+	}
+	args = append([]string{"-v", "error"}, args...)
+	log.Printf("[ffprobe] %s %s", p, strings.Join(args, " "))
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error: ffprobe failed: %w\n%s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// outputOffset converts an offset in seconds from the start of the playlist
+// to one from the start of the output made of chunks, accounting for the
+// chunks pruned before it and any skipped in between
+func outputOffset(chunks []Chunk, t float64) float64 {
+	offset := 0.0
+	for _, c := range chunks {
+		if t < c.Start+c.Length {
+			if t > c.Start {
+				offset += t - c.Start
+			}
+			return offset
+		}
+		offset += c.Length
+	}
+	return offset
+}
+
+// withoutGaps returns the chunks that made it into the output, leaving out the
+// ones skipped as gaps
+func withoutGaps(chunks []Chunk, gaps []Gap) []Chunk {
+	if len(gaps) == 0 {
+		return chunks
+	}
+	skipped := make(map[int]bool, len(gaps))
+	for _, g := range gaps {
+		skipped[g.Index] = true
+	}
+	res := make([]Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if !skipped[c.Index] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// replaceOutput has write create the file that replaces in as out (which may
+// be the same file), through a temp file next to out so that a failure leaves
+// in untouched
+func replaceOutput(in, out string, write func(tmp string) error) error {
+	ext := filepath.Ext(out)
+	tmp := strings.TrimSuffix(out, ext) + ".tmp" + ext
+	defer os.Remove(tmp)
+
+	err := write(tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, out)
+	if err != nil {
+		return err
+	}
+	if in != out {
+		return os.Remove(in)
+	}
+	return nil
+}
+
+// remuxOutput copies the streams in in into out's container (by its
+// extension) and removes in
+func remuxOutput(ctx context.Context, ff *ffmpeg, in, out string) error {
+	return replaceOutput(in, out, func(tmp string) error {
+		args := []string{"-i", in}
+		args = append(args, streamMaps(tmp)...)
+		args = append(args, "-c", "copy")
+		args = append(args, muxArgs(tmp)...)
+		return ff.run(ctx, append(args, tmp)...)
+	})
+}
+
+// streamMaps returns the options selecting the streams to write to a file:
+// just the audio for audio-only containers, and otherwise the video and audio.
+// Twitch streams also carry a timed metadata stream that most containers
+// can't hold, so that's always left out.
+func streamMaps(out string) []string {
+	switch strings.ToLower(filepath.Ext(out)) {
+	case "." + ContainerAAC, "." + ContainerM4A:
+		return []string{"-map", "0:a"}
+	}
+	return []string{"-map", "0:v?", "-map", "0:a?"}
+}
+
+// muxArgs returns the output options for a file's container
+func muxArgs(out string) []string {
+	switch strings.ToLower(filepath.Ext(out)) {
+	case "." + ContainerMP4, "." + ContainerM4A:
+		// put the moov atom first so that playback can start right away
+		return []string{"-movflags", "+faststart"}
+	}
+	return nil
+}
+
+// trimOutput writes the part of in from start to end (in seconds from its
+// start; end <= 0 keeps everything after start) to out with ffmpeg. Streams
+// are copied, so the cut lands on the keyframe at or before start unless
+// reencodeHead is set, in which case only the frames up to the next keyframe
+// are re-encoded to start exactly at start. Intermediate files go in tmpDir.
+func trimOutput(ctx context.Context, ff *ffmpeg, in, out, tmpDir string, start, end float64, reencodeHead bool) error {
+	return replaceOutput(in, out, func(tmp string) error {
+		if reencodeHead {
+			return trimReencodeHead(ctx, ff, in, tmp, tmpDir, start, end)
+		}
+		return trimCopy(ctx, ff, in, tmp, start, end)
+	})
+}
+
+// trimArgs returns the args to read from start to end of in into out
+func trimArgs(in, out string, start, end float64) []string {
+	args := []string{"-ss", formatFloat(start), "-i", in}
+	if end > 0 {
+		args = append(args, "-t", formatFloat(end-start))
+	}
+	return append(args, streamMaps(out)...)
+}
+
+func trimCopy(ctx context.Context, ff *ffmpeg, in, out string, start, end float64) error {
+	args := trimArgs(in, out, start, end)
+	args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	args = append(args, muxArgs(out)...)
+	return ff.run(ctx, append(args, out)...)
+}
+
+// headEncoders maps the video codecs whose head GOP can be re-encoded to the
+// encoder to use
+var headEncoders = map[string][]string{
+	"h264": {"-c:v", "libx264", "-preset", "veryfast", "-crf", "16"},
+	"hevc": {"-c:v", "libx265", "-preset", "veryfast", "-crf", "18"},
+}
+
+// trimReencodeHead re-encodes the frames from start to the first keyframe at
+// or after it, stream-copies the rest, and joins the two
+func trimReencodeHead(ctx context.Context, ff *ffmpeg, in, out, tmpDir string, start, end float64) error {
+	codec, startTime, err := probeVideo(ctx, ff, in)
+	if err != nil {
+		return err
+	}
+	encoder, ok := headEncoders[codec]
+	if !ok {
+		return fmt.Errorf("error: re-encoding the head of %s video is not supported; trim without TrimReencodeHead instead", codec)
+	}
+
+	keyframe, err := nextKeyframe(ctx, ff, in, startTime, start)
+	if err != nil {
+		return err
+	}
+	if keyframe < 0 || (end > 0 && keyframe >= end) {
+		log.Printf("[trimReencodeHead] no keyframe between %.3fs and the end, copying instead", start)
+		return trimCopy(ctx, ff, in, out, start, end)
+	}
+	if keyframe-start < 0.001 {
+		// already starts on a keyframe
+		return trimCopy(ctx, ff, in, out, start, end)
+	}
+	log.Printf("[trimReencodeHead] re-encoding %.3fs to keyframe at %.3fs", start, keyframe)
+
+	head := filepath.Join(tmpDir, "trim-head.ts")
+	tail := filepath.Join(tmpDir, "trim-tail.ts")
+	list := filepath.Join(tmpDir, "trim-concat.txt")
+	defer os.Remove(head)
+	defer os.Remove(tail)
+	defer os.Remove(list)
+
+	args := trimArgs(in, head, start, keyframe)
+	args = append(args, encoder...)
+	args = append(args, "-c:a", "copy", "-f", "mpegts", head)
+	err = ff.run(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	args = trimArgs(in, tail, keyframe, end)
+	args = append(args, "-c", "copy", "-f", "mpegts", tail)
+	err = ff.run(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	concat := fmt.Sprintf("file '%s'\nfile '%s'\n", filepath.ToSlash(head), filepath.ToSlash(tail))
+	err = ioutil.WriteFile(list, []byte(concat), 0644)
+	if err != nil {
+		return err
+	}
+	args = []string{"-f", "concat", "-safe", "0", "-i", list, "-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero"}
+	args = append(args, muxArgs(out)...)
+	return ff.run(ctx, append(args, out)...)
+}
+
+// probeVideo returns the codec of the first video stream in a file and the
+// file's start time, which keyframe timestamps are relative to
+func probeVideo(ctx context.Context, ff *ffmpeg, in string) (string, float64, error) {
+	out, err := ff.probe(ctx, "-select_streams", "v:0", "-show_entries", "stream=codec_name:format=start_time", "-of", "json", in)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var info struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+		}
+		Format struct {
+			StartTime string `json:"start_time"`
+		}
+	}
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return "", 0, fmt.Errorf("error: failed to parse ffprobe output: %w", err)
+	}
+	if len(info.Streams) == 0 {
+		return "", 0, fmt.Errorf("error: %s has no video to re-encode", in)
+	}
+	startTime, _ := strconv.ParseFloat(info.Format.StartTime, 64)
+
+	return info.Streams[0].CodecName, startTime, nil
+}
+
+// nextKeyframe returns the offset of the first video keyframe at or after
+// offset, or -1 if there isn't one within a minute of it
+func nextKeyframe(ctx context.Context, ff *ffmpeg, in string, startTime, offset float64) (float64, error) {
+	interval := fmt.Sprintf("%s%%+60", formatFloat(startTime+offset))
+	out, err := ff.probe(ctx, "-select_streams", "v:0", "-skip_frame", "nokey", "-show_entries", "frame=pts_time", "-of", "csv=p=0", "-read_intervals", interval, in)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		pts, err := strconv.ParseFloat(strings.TrimSpace(strings.Trim(line, ",")), 64)
+		if err != nil {
+			continue
+		}
+		if t := pts - startTime; t >= offset-0.001 {
+			return t, nil
+		}
+	}
+	return -1, nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
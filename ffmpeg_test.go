@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOutputOffset(t *testing.T) {
+	regular := testChunks(10, 10, 10, 10, 10)
+
+	tests := []struct {
+		name   string
+		chunks []Chunk
+		gaps   []Gap
+		t      float64
+		want   float64
+	}{
+		{"start of the output", regular, nil, 0, 0},
+		{"inside the first chunk", regular, nil, 5, 5},
+		{"on a chunk boundary", regular, nil, 20, 20},
+		{"inside a later chunk", regular, nil, 33.5, 33.5},
+		{"past the end", regular, nil, 60, 50},
+		{"chunks pruned before the start", regular[2:], nil, 25, 5},
+		{"before the first chunk left", regular[2:], nil, 15, 0},
+		{"after a gap", regular, []Gap{{Index: 1}}, 25, 15},
+		{"after several gaps", regular, []Gap{{Index: 1}, {Index: 3}}, 45, 25},
+		{"before a gap", regular, []Gap{{Index: 3}}, 25, 25},
+		{"inside a gap", regular, []Gap{{Index: 2}}, 25, 20},
+		{"pruned and a gap", regular[1:], []Gap{{Index: 2}}, 35, 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := outputOffset(withoutGaps(tt.chunks, tt.gaps), tt.t)
+			if got != tt.want {
+				t.Errorf("outputOffset(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithoutGaps(t *testing.T) {
+	chunks := testChunks(10, 10, 10, 10)
+
+	tests := []struct {
+		name string
+		gaps []Gap
+		want []int
+	}{
+		{"no gaps", nil, []int{0, 1, 2, 3}},
+		{"one gap", []Gap{{Index: 2}}, []int{0, 1, 3}},
+		{"adjacent gaps", []Gap{{Index: 0}, {Index: 1}}, []int{2, 3}},
+		{"every chunk", []Gap{{Index: 0}, {Index: 1}, {Index: 2}, {Index: 3}}, []int{}},
+		{"gap outside the chunks", []Gap{{Index: 7}}, []int{0, 1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withoutGaps(chunks, tt.gaps)
+			if len(got) != len(tt.want) {
+				t.Fatalf("withoutGaps() kept %d chunks, want %d", len(got), len(tt.want))
+			}
+			for i, c := range got {
+				if c.Index != tt.want[i] {
+					t.Errorf("withoutGaps()[%d] is chunk %d, want %d", i, c.Index, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// DefaultFilenameTemplate names the output after the VOD and the time range
+const DefaultFilenameTemplate = "{{.VodID}}-{{.Start}}-{{.End}}.{{.Ext}}"
+
+// maxFieldLength caps the length in bytes of each value in a filename, so that
+// long titles don't push it past the filesystem's limit
+const maxFieldLength = 100
+
+// FilenameData holds the values available to FilenameTemplate
+type FilenameData struct {
+	// VodID is the VOD's ID, or the name given to an HLS stream
+	VodID string
+	// Channel, Title, and Date (the VOD's creation date, e.g. "2021-03-04")
+	// are only known for Twitch VODs
+	Channel string
+	Title   string
+	Date    string
+	// Start and End are the time range of the output (e.g. "00h10m00s")
+	Start string
+	End   string
+	// Quality is the name of the downloaded variant (e.g. "1080p60")
+	Quality string
+	// Ext is the output's extension, without the dot (e.g. "mp4")
+	Ext string
+}
+
+// newFilenameData gathers the values for an output filename; info may be nil
+// if the VOD's metadata wasn't fetched
+func newFilenameData(id string, info *VODInfo, variant *Variant, startAt, dur float64, container string) FilenameData {
+	data := FilenameData{
+		VodID:   id,
+		Start:   formatSeconds(startAt),
+		End:     formatSeconds(startAt + dur),
+		Quality: strings.TrimSuffix(variant.Name, " (source)"),
+		Ext:     container,
+	}
+	if info != nil {
+		data.Channel = info.Channel
+		data.Title = info.Title
+		if !info.CreatedAt.IsZero() {
+			data.Date = info.CreatedAt.Local().Format("2006-01-02")
+		}
+	}
+	return data
+}
+
+// needsVODInfo reports whether a filename template uses any of the values
+// that come from the VOD's metadata
+func needsVODInfo(tmpl string) bool {
+	for _, field := range []string{".Channel", ".Title", ".Date"} {
+		if strings.Contains(tmpl, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderFilename fills in a filename template with data, sanitizing each value
+// so that it can't add path separators or characters the filesystem doesn't
+// allow. Slashes in the template itself are kept as subdirectories, and the
+// extension is added if the template leaves it out.
+func renderFilename(tmpl string, data FilenameData) (string, error) {
+	t, err := template.New("filename").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("error: FilenameTemplate is invalid: %w", err)
+	}
+
+	safe := FilenameData{
+		VodID:   sanitizeFilename(data.VodID),
+		Channel: sanitizeFilename(data.Channel),
+		Title:   sanitizeFilename(data.Title),
+		Date:    sanitizeFilename(data.Date),
+		Start:   sanitizeFilename(data.Start),
+		End:     sanitizeFilename(data.End),
+		Quality: sanitizeFilename(data.Quality),
+		Ext:     sanitizeFilename(data.Ext),
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, safe)
+	if err != nil {
+		return "", fmt.Errorf("error: FilenameTemplate is invalid: %w", err)
+	}
+
+	// empty values can leave empty path elements, which would otherwise make
+	// the path absolute or point at a folder
+	var parts []string
+	for _, p := range strings.Split(filepath.ToSlash(buf.String()), "/") {
+		if p = strings.TrimSpace(p); p != "" && p != "." {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("error: FilenameTemplate '%s' gave an empty filename", tmpl)
+	}
+	filename := filepath.Join(parts...)
+
+	if ext := "." + data.Ext; data.Ext != "" && !strings.HasSuffix(strings.ToLower(filename), strings.ToLower(ext)) {
+		filename += ext
+	}
+	return filename, nil
+}
+
+// sanitizeFilename makes a value safe to use as (part of) a file or folder
+// name, replacing path separators, control characters, and on Windows the
+// characters and names it reserves
+func sanitizeFilename(s string) string {
+	bad := "/\\"
+	if runtime.GOOS == "windows" {
+		bad += "<>:\"|?*"
+	}
+	s = strings.ToValidUTF8(s, "_")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(bad, r) {
+			return '_'
+		}
+		return r
+	}, s)
+
+	if len(s) > maxFieldLength {
+		s = s[:maxFieldLength]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+	}
+	s = strings.TrimSpace(s)
+	if runtime.GOOS == "windows" {
+		s = strings.TrimRight(s, ". ")
+	}
+	if s != "" && strings.Trim(s, ".") == "" {
+		// "." and ".." would point at a folder
+		s = strings.Repeat("_", len(s))
+	}
+	if s != "" && !isValidFilename(s) {
+		// a reserved name on Windows, like "CON"
+		s += "_"
+	}
+	return s
+}
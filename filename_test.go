@@ -0,0 +1,174 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	long := strings.Repeat("a", maxFieldLength-1) + "é"
+
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "Speedrun 120 stars", "Speedrun 120 stars"},
+		{"slashes", "AC/DC \\ live", "AC_DC _ live"},
+		{"control characters", "line\nbreak\ttab\x7f", "line_break_tab_"},
+		{"invalid UTF-8", "bad\xffbyte", "bad_byte"},
+		{"unicode", "日本語のタイトル 🎮", "日本語のタイトル 🎮"},
+		{"surrounding spaces", "  title  ", "title"},
+		{"dot", ".", "_"},
+		{"dot dot", "..", "__"},
+		{"dots inside", "v1.2..3", "v1.2..3"},
+		{"empty", "", ""},
+		{"too long", strings.Repeat("a", maxFieldLength+50), strings.Repeat("a", maxFieldLength)},
+		{"too long, cut inside a character", long, strings.Repeat("a", maxFieldLength-1)},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, []struct {
+			name string
+			s    string
+			want string
+		}{
+			{"reserved characters", `a<b>c:d"e|f?g*h`, "a_b_c_d_e_f_g_h"},
+			{"trailing dots", "title...", "title"},
+			{"reserved name", "CON", "CON_"},
+		}...)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.s); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderFilename(t *testing.T) {
+	data := FilenameData{
+		VodID:   "123",
+		Channel: "SomeBody",
+		Title:   "Any% / 120 star",
+		Date:    "2021-03-04",
+		Start:   "00h10m00s",
+		End:     "00h20m00s",
+		Quality: "1080p60",
+		Ext:     "mp4",
+	}
+	noInfo := data
+	noInfo.Channel, noInfo.Title, noInfo.Date = "", "", ""
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		data    FilenameData
+		want    string
+		wantErr bool
+	}{
+		{"default", DefaultFilenameTemplate, data, "123-00h10m00s-00h20m00s.mp4", false},
+		{"title with a slash", "{{.Channel}} - {{.Title}}.{{.Ext}}", data, "SomeBody - Any% _ 120 star.mp4", false},
+		{"subfolders", "{{.Channel}}/{{.Date}}/{{.Quality}}.{{.Ext}}", data, filepath.Join("SomeBody", "2021-03-04", "1080p60.mp4"), false},
+		{"extension added", "{{.VodID}}_{{.Quality}}", data, "123_1080p60.mp4", false},
+		{"extension in another case", "{{.VodID}}.MP4", data, "123.MP4", false},
+		{"empty folder", "{{.Channel}}/{{.VodID}}.{{.Ext}}", noInfo, "123.mp4", false},
+		{"leading slash", "/{{.VodID}}.{{.Ext}}", data, "123.mp4", false},
+		{"dot folder", "./{{.VodID}}.{{.Ext}}", data, "123.mp4", false},
+		{"empty", "{{.Title}}", noInfo, "", true},
+		{"unknown field", "{{.Nope}}", data, "", true},
+		{"bad syntax", "{{.VodID", data, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderFilename(tt.tmpl, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderFilename(%q) error = %v, wantErr %v", tt.tmpl, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderFilename(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildOutFilePath(t *testing.T) {
+	data := FilenameData{VodID: "123", Channel: "SomeBody", Start: "00h00m00s", End: "01h00m00s", Ext: "ts"}
+
+	tests := []struct {
+		name   string
+		tmpl   string
+		prefix string
+		folder string
+		want   string
+	}{
+		{"default", DefaultFilenameTemplate, "", "", "123-00h00m00s-01h00m00s.ts"},
+		{"prefix", DefaultFilenameTemplate, "vod_", "", "vod_123-00h00m00s-01h00m00s.ts"},
+		{"folder", DefaultFilenameTemplate, "", "vods", filepath.Join("vods", "123-00h00m00s-01h00m00s.ts")},
+		{"prefix on the file, not the subfolder", "{{.Channel}}/{{.VodID}}", "vod_", "vods", filepath.Join("vods", "SomeBody", "vod_123.ts")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildOutFilePath(tt.tmpl, data, tt.prefix, tt.folder)
+			if err != nil {
+				t.Fatalf("buildOutFilePath() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("buildOutFilePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFilenameData(t *testing.T) {
+	variant := &Variant{Name: "1080p60 (source)"}
+	info := &VODInfo{Channel: "SomeBody", Title: "Speedrun", CreatedAt: time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)}
+
+	got := newFilenameData("123", info, variant, 600, 1200, ContainerMP4)
+	want := FilenameData{
+		VodID:   "123",
+		Channel: "SomeBody",
+		Title:   "Speedrun",
+		Date:    info.CreatedAt.Local().Format("2006-01-02"),
+		Start:   "00h10m00s",
+		End:     "00h30m00s",
+		Quality: "1080p60",
+		Ext:     "mp4",
+	}
+	if got != want {
+		t.Errorf("newFilenameData() = %+v, want %+v", got, want)
+	}
+
+	// the VOD's info isn't fetched unless the template needs it
+	got = newFilenameData("123", nil, variant, 0, 10, ContainerTS)
+	if got.Channel != "" || got.Title != "" || got.Date != "" {
+		t.Errorf("newFilenameData() without info = %+v", got)
+	}
+}
+
+func TestNeedsVODInfo(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want bool
+	}{
+		{DefaultFilenameTemplate, false},
+		{"{{.VodID}}_{{.Quality}}", false},
+		{"{{.Channel}}/{{.VodID}}", true},
+		{"{{.Title}}", true},
+		{"{{.Date}}-{{.VodID}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			if got := needsVODInfo(tt.tmpl); got != tt.want {
+				t.Errorf("needsVODInfo(%q) = %v, want %v", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// ByteRange is the part of a resource a chunk is stored in (EXT-X-BYTERANGE)
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// Header returns the value of the HTTP Range header for the range
+func (r *ByteRange) Header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+// ChunkMap is the media initialization section (EXT-X-MAP) that fMP4 chunks
+// need in front of them to be playable
+type ChunkMap struct {
+	URI   *url.URL
+	Range *ByteRange
+}
+
+// parseChunkMap converts an EXT-X-MAP tag into a ChunkMap, resolving its URI
+// against the playlist URL
+func parseChunkMap(m *m3u8.Map, baseURL *url.URL) (*ChunkMap, error) {
+	if m.URI == "" {
+		return nil, fmt.Errorf("error: invalid EXT-X-MAP URI '%s'", m.URI)
+	}
+	mapURL, err := resolveURI(baseURL, m.URI)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid EXT-X-MAP URI '%s': %w", m.URI, err)
+	}
+	cm := &ChunkMap{URI: mapURL}
+	if m.Limit > 0 {
+		cm.Range = &ByteRange{Offset: m.Offset, Length: m.Limit}
+	}
+	return cm, nil
+}
+
+// sameMap reports whether two chunks use the same initialization section
+func sameMap(a, b *ChunkMap) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.URI.String() != b.URI.String() {
+		return false
+	}
+	if a.Range == nil || b.Range == nil {
+		return a.Range == b.Range
+	}
+	return *a.Range == *b.Range
+}
+
+// withInitSegments inserts an init chunk in front of the first chunk and
+// wherever the initialization section changes, and renumbers the chunks.
+// It's done after pruning so that the init segment is kept no matter where
+// the clip starts.
+func withInitSegments(chunks []Chunk) []Chunk {
+	var res []Chunk
+	var current *ChunkMap
+	for _, c := range chunks {
+		if c.Map != nil && (current == nil || !sameMap(c.Map, current)) {
+			current = c.Map
+			res = append(res, Chunk{
+				Index: len(res),
+				Name:  path.Base(c.Map.URI.Path),
+				URL:   c.Map.URI,
+				Range: c.Map.Range,
+				Init:  true,
+				// an encrypted init section uses the key in effect at its
+				// EXT-X-MAP, i.e. the one of the first chunk that uses it
+				Key:   c.Key,
+				SeqNo: c.SeqNo,
+				Start: c.Start,
+			})
+		}
+		c.Index = len(res)
+		res = append(res, c)
+	}
+	return res
+}
+
+// videoCodec returns the family ("avc", "hevc", or "av1") of the video codec
+// in a variant's CODECS attribute, or "" if there isn't a known one
+func videoCodec(codecs string) string {
+	for _, c := range strings.Split(codecs, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch {
+		case strings.HasPrefix(c, "avc1"), strings.HasPrefix(c, "avc3"):
+			return "avc"
+		case strings.HasPrefix(c, "hvc1"), strings.HasPrefix(c, "hev1"):
+			return "hevc"
+		case strings.HasPrefix(c, "av01"):
+			return "av1"
+		}
+	}
+	return ""
+}
+
+// isFMP4 reports whether a chunk is a fragmented MP4 (CMAF) segment rather
+// than MPEG-TS
+func isFMP4(c Chunk) bool {
+	if c.Init || c.Map != nil {
+		return true
+	}
+	switch strings.ToLower(path.Ext(c.URL.Path)) {
+	case ".m4s", ".mp4", ".m4v", ".m4a", ".cmfv", ".cmfa":
+		return true
+	}
+	return false
+}
+
+// validateMP4 checks that data is a sequence of complete MP4 boxes, and that
+// an init segment holds a "moov" box and a media segment a "moof" and "mdat"
+func validateMP4(c Chunk, data []byte) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Chunk: c.Name, Reason: fmt.Sprintf(format, args...)}
+	}
+	if len(data) == 0 {
+		return fail("empty payload")
+	}
+
+	seen := make(map[string]bool)
+	for offset := int64(0); offset < int64(len(data)); {
+		if int64(len(data))-offset < 8 {
+			return fail("truncated MP4 box header at offset %d", offset)
+		}
+		size := int64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// the box extends to the end of the segment
+			size = int64(len(data)) - offset
+		case 1:
+			if int64(len(data))-offset < 16 {
+				return fail("truncated MP4 box header at offset %d", offset)
+			}
+			size = int64(binary.BigEndian.Uint64(data[offset+8:]))
+			headerSize = 16
+		}
+		for _, ch := range boxType {
+			if ch < 0x20 || ch > 0x7e {
+				return fail("invalid MP4 box type %q at offset %d", boxType, offset)
+			}
+		}
+		if size < headerSize || size > int64(len(data))-offset {
+			return fail("invalid size %d for MP4 box '%s' at offset %d", size, boxType, offset)
+		}
+		seen[boxType] = true
+		offset += size
+	}
+
+	if c.Init && !seen["moov"] {
+		return fail("init segment has no 'moov' box")
+	}
+	if !c.Init && (!seen["moof"] || !seen["mdat"]) {
+		return fail("fMP4 segment has no 'moof' and 'mdat' boxes")
+	}
+	return nil
+}
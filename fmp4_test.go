@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// mp4Box builds an MP4 box with a 32-bit size
+func mp4Box(boxType string, body []byte) []byte {
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], boxType)
+	return append(b, body...)
+}
+
+// mp4LargeBox builds an MP4 box with a 64-bit size
+func mp4LargeBox(boxType string, body []byte) []byte {
+	b := make([]byte, 16, 16+len(body))
+	binary.BigEndian.PutUint32(b, 1)
+	copy(b[4:], boxType)
+	binary.BigEndian.PutUint64(b[8:], uint64(16+len(body)))
+	return append(b, body...)
+}
+
+func TestValidateMP4(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("iso5\x00\x00\x02\x00iso6mp41"))
+	moov := mp4Box("moov", mp4Box("mvhd", make([]byte, 100)))
+	styp := mp4Box("styp", []byte("msdh\x00\x00\x00\x00msdhmsix"))
+	moof := mp4Box("moof", mp4Box("mfhd", make([]byte, 8)))
+	mdat := mp4Box("mdat", bytes.Repeat([]byte{0xab}, 500))
+	segment := bytes.Join([][]byte{styp, moof, mdat}, nil)
+
+	toEnd := mp4Box("mdat", bytes.Repeat([]byte{0xab}, 500))
+	binary.BigEndian.PutUint32(toEnd, 0)
+
+	tooBig := mp4Box("mdat", bytes.Repeat([]byte{0xab}, 500))
+	binary.BigEndian.PutUint32(tooBig, 1000)
+
+	tooSmall := mp4Box("mdat", bytes.Repeat([]byte{0xab}, 500))
+	binary.BigEndian.PutUint32(tooSmall, 4)
+
+	largeTooSmall := mp4LargeBox("mdat", bytes.Repeat([]byte{0xab}, 500))
+	binary.BigEndian.PutUint64(largeTooSmall[8:], 8)
+
+	largeOverflow := mp4LargeBox("mdat", bytes.Repeat([]byte{0xab}, 500))
+	binary.BigEndian.PutUint64(largeOverflow[8:], 1<<63)
+
+	badType := mp4Box("md\x00t", bytes.Repeat([]byte{0xab}, 500))
+
+	tests := []struct {
+		name    string
+		init    bool
+		data    []byte
+		wantErr bool
+	}{
+		{"init segment", true, append(ftyp, moov...), false},
+		{"media segment", false, segment, false},
+		{"media segment without styp", false, append(moof, mdat...), false},
+		{"mdat to the end of the segment", false, append(moof, toEnd...), false},
+		{"64-bit box size", false, append(moof, mp4LargeBox("mdat", bytes.Repeat([]byte{0xab}, 500))...), false},
+		{"empty", false, nil, true},
+		{"init segment without moov", true, ftyp, true},
+		{"media segment without moof", false, append(styp, mdat...), true},
+		{"media segment without mdat", false, append(styp, moof...), true},
+		{"media segment as init segment", true, segment, true},
+		{"truncated box header", false, append(segment, 0x00, 0x00, 0x00), true},
+		{"truncated 64-bit box header", false, append(moof, mp4LargeBox("mdat", nil)[:12]...), true},
+		{"box past the end", false, append(moof, tooBig...), true},
+		{"truncated box", false, segment[:len(segment)-1], true},
+		{"size smaller than the header", false, append(moof, tooSmall...), true},
+		{"64-bit size smaller than the header", false, append(moof, largeTooSmall...), true},
+		{"64-bit size that overflows", false, append(moof, largeOverflow...), true},
+		{"invalid box type", false, append(moof, badType...), true},
+		{"MPEG-TS", false, bytes.Repeat(append([]byte{0x47}, make([]byte, TSPacketSize-1)...), 3), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMP4(Chunk{Name: "0.m4s", Init: tt.init}, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateMP4() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var validationErr *ValidationError
+			if err != nil && !errors.As(err, &validationErr) {
+				t.Errorf("validateMP4() error = %v, want a ValidationError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"text/tabwriter"
+)
+
+// Format describes one of the variants of a VOD for the formats command
+type Format struct {
+	Name          string  `json:"name"`
+	Group         string  `json:"group"`
+	Resolution    string  `json:"resolution,omitempty"`
+	FrameRate     float64 `json:"fps,omitempty"`
+	Bandwidth     uint32  `json:"bandwidth"`
+	Codecs        string  `json:"codecs,omitempty"`
+	AudioOnly     bool    `json:"audioOnly"`
+	EstimatedSize uint64  `json:"estimatedSize"`
+}
+
+// FormatList is the output of the formats command: the variants of a VOD and
+// the time range their sizes are estimated for
+type FormatList struct {
+	VodID    int      `json:"vodID"`
+	Start    float64  `json:"start"`
+	Duration float64  `json:"duration"`
+	Formats  []Format `json:"formats"`
+}
+
+// ListFormats prints the variants available for a VOD along with their
+// estimated size for the configured time range, as a table or as JSON
+func ListFormats(ctx context.Context, cfg Config, asJSON bool) error {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Println("[ListFormats] fetching access token")
+	ar, err := getAccessData(ctx, client, cfg.VodID, cfg.ClientID)
+	if err != nil {
+		return err
+	}
+
+	log.Println("[ListFormats] fetching VOD stream options")
+	variants, err := getStreamOptions(ctx, client, cfg.VodID, ar)
+	if err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return fmt.Errorf("error: no variants found for VOD %d", cfg.VodID)
+	}
+
+	list, err := buildFormatList(ctx, cfg, client, variants)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	return printFormats(os.Stdout, list)
+}
+
+// buildFormatList works out the length of the configured time range from the
+// chunk list of one of the variants (they all share the same timeline) and
+// estimates each variant's size for it
+func buildFormatList(ctx context.Context, cfg Config, client *http.Client, variants []*Variant) (FormatList, error) {
+	list := FormatList{VodID: cfg.VodID}
+
+	padding, err := parseOptionalDuration(cfg.Padding)
+	if err != nil {
+		return list, fmt.Errorf("error: Padding is invalid: %w", err)
+	}
+
+	ref := variants[0]
+	if video := videoVariants(variants); len(video) > 0 {
+		ref = video[0]
+	}
+	chunks, err := getChunks(ctx, client, ref.URI)
+	if err != nil {
+		return list, err
+	}
+	_, list.Start, list.Duration, err = pruneChunks(chunks, cfg.StartSec, cfg.EndSec, padding.Seconds())
+	if err != nil {
+		return list, err
+	}
+
+	for _, v := range variants {
+		list.Formats = append(list.Formats, Format{
+			Name:          v.Name,
+			Group:         v.Group,
+			Resolution:    v.Resolution,
+			FrameRate:     math.Round(v.FrameRate*100) / 100,
+			Bandwidth:     v.Bandwidth,
+			Codecs:        v.Codecs,
+			AudioOnly:     v.AudioOnly,
+			EstimatedSize: estimateSize(v.Bandwidth, list.Duration),
+		})
+	}
+	return list, nil
+}
+
+// printFormats writes the format list as a table
+func printFormats(w io.Writer, list FormatList) error {
+	fmt.Fprintf(w, "VOD %d, %s to %s (%s)\n\n", list.VodID,
+		formatSeconds(list.Start), formatSeconds(list.Start+list.Duration), formatSeconds(list.Duration))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRESOLUTION\tFPS\tBANDWIDTH\tCODECS\tEST. SIZE")
+	for _, f := range list.Formats {
+		resolution, fps := f.Resolution, ""
+		if f.AudioOnly {
+			resolution = "audio only"
+		}
+		if f.FrameRate > 0 {
+			fps = fmt.Sprintf("%g", f.FrameRate)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d kbps\t%s\t~%s\n",
+			f.Name, resolution, fps, f.Bandwidth/1000, f.Codecs, formatBytes(int64(f.EstimatedSize)))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testMediaPlaylist is a VOD of six 10-second chunks
+const testMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:10.000,
+0.ts
+#EXTINF:10.000,
+1.ts
+#EXTINF:10.000,
+2.ts
+#EXTINF:10.000,
+3.ts
+#EXTINF:10.000,
+4.ts
+#EXTINF:10.000,
+5.ts
+#EXT-X-ENDLIST
+`
+
+func TestBuildFormatList(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		fmt.Fprint(w, testMediaPlaylist)
+	}))
+	defer srv.Close()
+
+	// the audio comes first, but the timeline is taken from the smallest
+	// video variant
+	variants := []*Variant{
+		{Name: "Audio Only", Group: audioOnlyGroup, Bandwidth: 160000, Codecs: "mp4a.40.2", AudioOnly: true, URI: srv.URL + "/audio_only/index.m3u8"},
+		{Name: "1080p60 (source)", Group: "chunked", Resolution: "1920x1080", Height: 1080, FrameRate: 59.9401, Bandwidth: 8000000, URI: srv.URL + "/chunked/index.m3u8"},
+		{Name: "720p30", Group: "720p30", Resolution: "1280x720", Height: 720, FrameRate: 30, Bandwidth: 2000000, URI: srv.URL + "/720p30/index.m3u8"},
+	}
+
+	tests := []struct {
+		name          string
+		startSec      int
+		endSec        int
+		padding       string
+		wantStart     float64
+		wantDuration  float64
+		wantSourceEst uint64
+		wantErr       bool
+	}{
+		{"whole VOD", 0, -1, "", 0, 60, 60000000, false},
+		{"range", 10, 40, "", 10, 30, 30000000, false},
+		{"range within chunks", 15, 35, "", 10, 30, 30000000, false},
+		{"padded range", 20, 30, "10s", 10, 30, 30000000, false},
+		{"invalid padding", 0, -1, "soon", 0, 0, 0, true},
+		{"start past the end", 100, -1, "", 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested = nil
+			cfg := Config{VodID: 123, StartSec: tt.startSec, EndSec: tt.endSec, Padding: tt.padding}
+			list, err := buildFormatList(context.Background(), cfg, srv.Client(), variants)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildFormatList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(requested) != 1 || requested[0] != "/720p30/index.m3u8" {
+				t.Errorf("buildFormatList() fetched %v, want only the smallest video variant", requested)
+			}
+			if list.VodID != 123 || list.Start != tt.wantStart || list.Duration != tt.wantDuration {
+				t.Errorf("buildFormatList() = VOD %d from %v for %v, want VOD 123 from %v for %v", list.VodID, list.Start, list.Duration, tt.wantStart, tt.wantDuration)
+			}
+			if len(list.Formats) != len(variants) {
+				t.Fatalf("buildFormatList() listed %d formats, want %d", len(list.Formats), len(variants))
+			}
+			source := list.Formats[1]
+			if source.Name != "1080p60 (source)" || source.FrameRate != 59.94 || source.EstimatedSize != tt.wantSourceEst {
+				t.Errorf("source format = %+v, want 59.94fps and ~%d bytes", source, tt.wantSourceEst)
+			}
+		})
+	}
+}
+
+func TestPrintFormats(t *testing.T) {
+	list := FormatList{
+		VodID:    123,
+		Start:    10,
+		Duration: 3600,
+		Formats: []Format{
+			{Name: "1080p60 (source)", Resolution: "1920x1080", FrameRate: 60, Bandwidth: 8000000, Codecs: "avc1.64002A,mp4a.40.2", EstimatedSize: 3600000000},
+			{Name: "Audio Only", Bandwidth: 160000, Codecs: "mp4a.40.2", AudioOnly: true, EstimatedSize: 72000000},
+		},
+	}
+
+	var b bytes.Buffer
+	err := printFormats(&b, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	want := []struct {
+		line   int
+		fields []string
+	}{
+		{0, []string{"VOD", "123,", "00h00m10s", "to", "01h00m10s", "(01h00m00s)"}},
+		{2, []string{"NAME", "RESOLUTION", "FPS", "BANDWIDTH", "CODECS", "EST.", "SIZE"}},
+		{3, []string{"1080p60", "(source)", "1920x1080", "60", "8000", "kbps", "avc1.64002A,mp4a.40.2", "~3.4G"}},
+		{4, []string{"Audio", "Only", "audio", "only", "160", "kbps", "mp4a.40.2", "~68.7M"}},
+	}
+	if len(lines) != 5 {
+		t.Fatalf("printFormats() wrote %d lines, want 5:\n%s", len(lines), b.String())
+	}
+	for _, w := range want {
+		if got := strings.Fields(lines[w.line]); strings.Join(got, " ") != strings.Join(w.fields, " ") {
+			t.Errorf("line %d = %q, want fields %q", w.line, lines[w.line], w.fields)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// DownloadHLS downloads from any HLS master or media playlist, given as a URL
+// or a local file, using the same quality selection, time range, and worker
+// settings as a Twitch VOD
+func DownloadHLS(ctx context.Context, cfg Config) error {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	playlistURL, err := resolveInput(cfg.HLSInput)
+	if err != nil {
+		return err
+	}
+	log.Printf("[DownloadHLS] playlist: %s", playlistURL)
+
+	fmt.Println("Fetching playlist")
+	p, listType, err := fetchPlaylist(ctx, client, playlistURL)
+	if err != nil {
+		return err
+	}
+
+	variant := &Variant{Name: "default", URI: playlistURL}
+	if listType == m3u8.MASTER {
+		variants := newVariants(p.(*m3u8.MasterPlaylist), playlistURL)
+		log.Printf("qualities options found: %+v\n", variants)
+
+		fmt.Println("Picking selected quality")
+		variant, err = pickVariant(variants, cfg.Quality)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Println("[DownloadHLS] got a media playlist, ignoring quality")
+	}
+
+	return downloadStream(ctx, cfg, client, hlsID(playlistURL), nil, variant)
+}
+
+// resolveInput turns the HLS input into a URL, converting local paths to
+// file:// URLs
+func resolveInput(input string) (string, error) {
+	u, err := url.Parse(input)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "file") {
+		return input, nil
+	}
+
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return "", err
+	}
+	_, err = os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("error: input must be an http(s) URL or an existing file: %w", err)
+	}
+
+	return fileURL(abs), nil
+}
+
+// isLocalInput reports whether the HLS input is a local file (a path or a
+// file:// URL) rather than an http(s) URL
+func isLocalInput(input string) bool {
+	u, err := url.Parse(input)
+	return err != nil || (u.Scheme != "http" && u.Scheme != "https")
+}
+
+// resolveURI resolves a URI from a playlist against the playlist's URL. Only
+// a local playlist may refer to local files, so that a remote one can't have
+// files on disk copied into the output.
+func resolveURI(baseURL *url.URL, uri string) (*url.URL, error) {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	u := baseURL.ResolveReference(ref)
+	if u.Scheme == "file" && baseURL.Scheme != "file" {
+		return nil, fmt.Errorf("error: remote playlist <%s> refers to local file <%s>", baseURL.Redacted(), u)
+	}
+	return u, nil
+}
+
+// fileURL converts an absolute path to a file:// URL
+func fileURL(abs string) string {
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		// Windows drive letter paths
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// hlsID names an HLS download after its playlist, plus a short hash of the
+// playlist's location (without the query string, which often holds expiring
+// tokens) so that different streams with the same playlist name don't collide
+func hlsID(playlistURL string) string {
+	u, err := url.Parse(playlistURL)
+	if err != nil {
+		u = &url.URL{Path: playlistURL}
+	}
+
+	name := strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))
+	name = regexp.MustCompile(`[^A-Za-z0-9_.-]`).ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == "_" {
+		name = "hls"
+	}
+
+	sum := sha1.Sum([]byte(u.Scheme + "://" + u.Host + u.Path))
+	return fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:])[:8])
+}
+
+// fileTransport serves file:// URLs from the local filesystem so that local
+// playlists (and any local segments they reference) go through the same
+// client as everything else
+type fileTransport struct{}
+
+func (fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := req.URL.Path
+	if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		// Windows drive letter paths, e.g. /C:/vods/index.m3u8
+		p = p[1:]
+	}
+
+	rsp := &http.Response{
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	f, err := os.Open(filepath.FromSlash(p))
+	if os.IsNotExist(err) {
+		rsp.StatusCode = http.StatusNotFound
+		rsp.Status = "404 Not Found"
+		rsp.Body = ioutil.NopCloser(strings.NewReader(""))
+		return rsp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("error: <%s> is a directory", p)
+	}
+
+	rsp.StatusCode = http.StatusOK
+	rsp.Status = "200 OK"
+	rsp.ContentLength = info.Size()
+	rsp.Body = f
+	return rsp, nil
+}
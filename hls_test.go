@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveURI(t *testing.T) {
+	remote, _ := url.Parse("https://cdn.example.com/vod/720p/index.m3u8?token=abc")
+	local, _ := url.Parse("file:///home/user/vods/index.m3u8")
+
+	tests := []struct {
+		name    string
+		base    *url.URL
+		uri     string
+		want    string
+		wantErr bool
+	}{
+		{"relative", remote, "0.ts", "https://cdn.example.com/vod/720p/0.ts", false},
+		{"relative with a query", remote, "0.ts?t=1", "https://cdn.example.com/vod/720p/0.ts?t=1", false},
+		{"parent dir", remote, "../key.bin", "https://cdn.example.com/vod/key.bin", false},
+		{"root relative", remote, "/other/0.ts", "https://cdn.example.com/other/0.ts", false},
+		{"protocol relative", remote, "//other.example.com/0.ts", "https://other.example.com/0.ts", false},
+		{"absolute", remote, "http://other.example.com/0.ts", "http://other.example.com/0.ts", false},
+		{"local file from a remote playlist", remote, "file:///etc/passwd", "", true},
+		{"local file from a remote playlist, upper case", remote, "FILE:///etc/passwd", "", true},
+		{"relative from a local playlist", local, "0.ts", "file:///home/user/vods/0.ts", false},
+		{"local file from a local playlist", local, "file:///mnt/vods/0.ts", "file:///mnt/vods/0.ts", false},
+		{"remote from a local playlist", local, "https://cdn.example.com/0.ts", "https://cdn.example.com/0.ts", false},
+		{"unparseable", remote, "%zz", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveURI(tt.base, tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveURI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("resolveURI(%q) = %s, want %s", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveInput(t *testing.T) {
+	dir := t.TempDir()
+	playlist := filepath.Join(dir, "index.m3u8")
+	err := ioutil.WriteFile(playlist, []byte("#EXTM3U\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		want      string
+		wantLocal bool
+		wantErr   bool
+	}{
+		{"https URL", "https://cdn.example.com/index.m3u8", "https://cdn.example.com/index.m3u8", false, false},
+		{"http URL", "http://cdn.example.com/index.m3u8", "http://cdn.example.com/index.m3u8", false, false},
+		{"file URL", "file:///home/user/index.m3u8", "file:///home/user/index.m3u8", true, false},
+		{"local path", playlist, fileURL(playlist), true, false},
+		{"missing local path", filepath.Join(dir, "missing.m3u8"), "", true, true},
+		{"unsupported scheme", "ftp://cdn.example.com/index.m3u8", "", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLocalInput(tt.input); got != tt.wantLocal {
+				t.Errorf("isLocalInput(%q) = %v, want %v", tt.input, got, tt.wantLocal)
+			}
+			got, err := resolveInput(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveInput(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveInput(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHLSID(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b       string
+		wantPrefix string
+		wantSame   bool
+	}{
+		{"same playlist with a new token", "https://cdn.example.com/vod/index.m3u8?token=a", "https://cdn.example.com/vod/index.m3u8?token=b", "index-", true},
+		{"same name on another host", "https://cdn.example.com/vod/index.m3u8", "https://other.example.com/vod/index.m3u8", "index-", false},
+		{"same name in another dir", "https://cdn.example.com/a/index.m3u8", "https://cdn.example.com/b/index.m3u8", "index-", false},
+		{"unsafe characters", "https://cdn.example.com/my vod!.m3u8", "https://cdn.example.com/my vod!.m3u8", "my_vod_-", true},
+		{"no name", "https://cdn.example.com/", "https://cdn.example.com/", "hls-", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := hlsID(tt.a), hlsID(tt.b)
+			if !strings.HasPrefix(a, tt.wantPrefix) {
+				t.Errorf("hlsID(%q) = %q, want it to start with %q", tt.a, a, tt.wantPrefix)
+			}
+			if (a == b) != tt.wantSame {
+				t.Errorf("hlsID(%q) = %q and hlsID(%q) = %q, want same: %v", tt.a, a, tt.b, b, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := t.TempDir()
+	playlist := filepath.Join(dir, "index.m3u8")
+	err := ioutil.WriteFile(playlist, []byte("#EXTM3U\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"existing file", playlist, http.StatusOK, false},
+		{"missing file", filepath.Join(dir, "missing.ts"), http.StatusNotFound, false},
+		{"directory", dir, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", fileURL(tt.path), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsp, err := fileTransport{}.RoundTrip(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoundTrip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer rsp.Body.Close()
+			if rsp.StatusCode != tt.wantStatus {
+				t.Errorf("RoundTrip() status = %d, want %d", rsp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
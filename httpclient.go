@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newHTTPClient builds the client used for every request tvd makes, based on
+// the timeout, header, connection, and proxy settings in the config
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	connectTimeout, err := parseOptionalDuration(cfg.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error: ConnectTimeout is invalid: %w", err)
+	}
+	readTimeout, err := parseOptionalDuration(cfg.ReadTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error: ReadTimeout is invalid: %w", err)
+	}
+	timeout, err := parseOptionalDuration(cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error: Timeout is invalid: %w", err)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := parseProxy(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || readTimeout == 0 {
+				return conn, err
+			}
+			return &idleTimeoutConn{Conn: conn, timeout: readTimeout}, nil
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: readTimeout,
+	}
+
+	// local files are only read for a local playlist given to tvd hls
+	if cfg.HLSInput != "" && isLocalInput(cfg.HLSInput) {
+		transport.RegisterProtocol("file", fileTransport{})
+	}
+
+	// the default isn't part of DefaultConfig so that it isn't written into
+	// config files and stays current when tvd is updated
+	userAgent := cfg.UserAgent
+	if userAgent == "" {
+		userAgent = "tvd/" + version
+	}
+	headers := make(http.Header)
+	headers.Set("User-Agent", userAgent)
+	for k, v := range cfg.Headers {
+		headers.Set(k, v)
+	}
+
+	log.Printf("[newHTTPClient] connect timeout=%s read timeout=%s total timeout=%s max conns/host=%d", connectTimeout, readTimeout, timeout, cfg.MaxConnsPerHost)
+
+	return &http.Client{
+		Transport: &headerTransport{base: transport, headers: headers},
+		Timeout:   timeout,
+	}, nil
+}
+
+// headerTransport adds a fixed set of headers to every request that doesn't
+// already set them
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		if req.Header.Get(k) == "" {
+			req.Header[k] = v
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// idleTimeoutConn fails a read that receives nothing for longer than timeout,
+// so a stalled transfer is caught without limiting how long a whole (possibly
+// large or rate-limited) response may take
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// parseOptionalDuration parses a Go duration string (e.g. "30s"), treating an
+// empty string as no timeout
+func parseOptionalDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration must not be negative; got '%s'", s)
+	}
+	return d, nil
+}
+
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("error: Proxy is invalid: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("error: Proxy must be an http, https, or socks5 URL; got '%s'", s)
+	}
+	return u, nil
+}
+
+// parseHeader splits a "Name: value" header
+func parseHeader(s string) (string, string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("error: header must be in format 'Name: value'; got '%s'", s)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
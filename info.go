@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ShowVODInfo prints a VOD's metadata, as text or as JSON
+func ShowVODInfo(ctx context.Context, cfg Config, asJSON bool) error {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	info, err := getVODInfo(ctx, client, cfg.VodID, cfg.ClientID)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	return printVODInfo(os.Stdout, info)
+}
+
+// printVODInfo writes a VOD's metadata as a list of fields
+func printVODInfo(w io.Writer, info VODInfo) error {
+	channel := info.Channel
+	if info.ChannelLogin != "" && !strings.EqualFold(info.ChannelLogin, channel) {
+		channel = fmt.Sprintf("%s (%s)", channel, info.ChannelLogin)
+	}
+	created := ""
+	if !info.CreatedAt.IsZero() {
+		created = info.CreatedAt.Local().Format(time.RFC1123)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%d\n", info.ID)
+	fmt.Fprintf(tw, "Title:\t%s\n", info.Title)
+	fmt.Fprintf(tw, "Channel:\t%s\n", channel)
+	fmt.Fprintf(tw, "Game:\t%s\n", info.Game)
+	fmt.Fprintf(tw, "Created:\t%s\n", created)
+	fmt.Fprintf(tw, "Length:\t%s\n", secondsToTimeMask(info.Length))
+	fmt.Fprintf(tw, "Views:\t%d\n", info.ViewCount)
+	fmt.Fprintf(tw, "Type:\t%s\n", info.Type)
+	return tw.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gqlStub answers every request with body, recording the last request
+type gqlStub struct {
+	body string
+	req  *http.Request
+}
+
+func (s *gqlStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.req = req
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(s.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestGetVODInfo(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    VODInfo
+		wantErr bool
+	}{
+		{
+			name: "everything",
+			body: `{"data":{"video":{"id":"123","title":"Speedrun!","createdAt":"2021-03-04T05:06:07Z","lengthSeconds":3725,"viewCount":42,"broadcastType":"ARCHIVE","game":{"name":"super mario 64","displayName":"Super Mario 64"},"owner":{"login":"somebody","displayName":"SomeBody"}}}}`,
+			want: VODInfo{ID: 123, Title: "Speedrun!", Channel: "SomeBody", ChannelLogin: "somebody", CreatedAt: created, Length: 3725, Game: "Super Mario 64", ViewCount: 42, Type: "archive"},
+		},
+		{
+			name: "no display names",
+			body: `{"data":{"video":{"id":"123","title":"Speedrun!","broadcastType":"HIGHLIGHT","game":{"name":"super mario 64"},"owner":{"login":"somebody"}}}}`,
+			want: VODInfo{ID: 123, Title: "Speedrun!", Channel: "somebody", ChannelLogin: "somebody", Game: "super mario 64", Type: "highlight"},
+		},
+		{
+			name: "no game or owner",
+			body: `{"data":{"video":{"id":"123","title":"Speedrun!","game":null,"owner":null}}}`,
+			want: VODInfo{ID: 123, Title: "Speedrun!"},
+		},
+		{name: "not found", body: `{"data":{"video":null}}`, wantErr: true},
+		{name: "GQL error", body: `{"errors":[{"message":"service timeout"}],"data":{"video":null}}`, wantErr: true},
+		{name: "not JSON", body: `<html>Bad Gateway</html>`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &gqlStub{body: tt.body}
+			client := &http.Client{Transport: stub}
+
+			got, err := getVODInfo(context.Background(), client, 123, "my-client-id")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getVODInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stub.req.URL.String() != gqlURL || stub.req.Header.Get("Client-ID") != "my-client-id" {
+				t.Errorf("getVODInfo() requested <%s> with Client-ID %q", stub.req.URL, stub.req.Header.Get("Client-ID"))
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getVODInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintVODInfo(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		info        VODInfo
+		wantChannel string
+		wantCreated string
+	}{
+		{"login differs", VODInfo{ID: 123, Channel: "Some Body", ChannelLogin: "somebody", CreatedAt: created, Length: 3725}, "Some Body (somebody)", created.Local().Format(time.RFC1123)},
+		{"login only differs in case", VODInfo{ID: 123, Channel: "SomeBody", ChannelLogin: "somebody", Length: 3725}, "SomeBody", ""},
+		{"no login", VODInfo{ID: 123, Channel: "SomeBody", Length: 3725}, "SomeBody", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := printVODInfo(&b, tt.info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields := make(map[string]string)
+			for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+				parts := strings.SplitN(line, ":", 2)
+				fields[parts[0]] = strings.TrimSpace(parts[1])
+			}
+			if fields["ID"] != "123" || fields["Length"] != "01h02m05s" {
+				t.Errorf("printVODInfo() ID = %q, Length = %q, want 123 and 01h02m05s", fields["ID"], fields["Length"])
+			}
+			if fields["Channel"] != tt.wantChannel {
+				t.Errorf("printVODInfo() Channel = %q, want %q", fields["Channel"], tt.wantChannel)
+			}
+			if fields["Created"] != tt.wantCreated {
+				t.Errorf("printVODInfo() Created = %q, want %q", fields["Created"], tt.wantCreated)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ManifestFile is the name of the manifest file kept inside a work directory
+const ManifestFile = "manifest.jsonl"
+
+// Manifest records which chunks of a download have been appended to the
+// partial output file so that an interrupted download can be resumed without
+// fetching them again.
+//
+// On disk it is a JSON lines file: a header line followed by one entry per
+// appended chunk, so recording a chunk is a single append regardless of how
+// long the VOD is.
+type Manifest struct {
+	manifestHeader
+
+	entries []ManifestEntry
+	path    string
+	f       *os.File
+}
+
+type manifestHeader struct {
+	// ID is the VOD ID, or a name derived from the playlist in HLS mode
+	ID string
+	// Variant is the key of the rendition being downloaded (see Variant.Key)
+	Variant  string
+	StartSec int
+	EndSec   int
+	// Output is the partial output file the entries were appended to
+	Output string
+}
+
+// ManifestEntry describes a chunk that was appended to the output file
+type ManifestEntry struct {
+	Index int
+	Name  string
+	Size  int64
+	Hash  string
+	// Error is set if the chunk was skipped because it failed to download
+	Error string `json:",omitempty"`
+}
+
+// workDirName builds a stable directory name for a download so that re-runs
+// of the same VOD (or playlist), rendition, and range share the same work
+// directory
+func workDirName(id string, variant string, startSec, endSec int) string {
+	unsafe := regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	return fmt.Sprintf("tvd_%s_%s_%d_%d", unsafe.ReplaceAllString(id, "_"), unsafe.ReplaceAllString(variant, "_"), startSec, endSec)
+}
+
+// prepareWorkDir creates (or reuses) the work directory for a download inside
+// baseDir (or the system temp dir if empty) and loads its manifest. variant is
+// the key of the rendition that was picked rather than the requested quality,
+// since "best" or a preference list can pick another one on a re-run, whose
+// chunks mustn't be appended to the old one's.
+func prepareWorkDir(baseDir string, id string, variant string, startSec, endSec int) (string, *Manifest, error) {
+	if baseDir == "" {
+		baseDir = os.TempDir()
+	}
+	workDir := filepath.Join(baseDir, workDirName(id, variant, startSec, endSec))
+	log.Printf("[prepareWorkDir] using work dir <%s>\n", workDir)
+
+	err := os.MkdirAll(workDir, 0755)
+	if err != nil {
+		return "", nil, err
+	}
+
+	m, err := loadManifest(filepath.Join(workDir, ManifestFile))
+	if err != nil {
+		return "", nil, err
+	}
+	if m.Len() > 0 && (m.ID != id || m.Variant != variant || m.StartSec != startSec || m.EndSec != endSec) {
+		// sanitizing can give two downloads the same work dir name
+		log.Printf("[prepareWorkDir] manifest is for %s/%s %d-%d, starting over\n", m.ID, m.Variant, m.StartSec, m.EndSec)
+		m = &Manifest{path: m.path}
+	}
+	m.ID = id
+	m.Variant = variant
+	m.StartSec = startSec
+	m.EndSec = endSec
+
+	return workDir, m, nil
+}
+
+func loadManifest(path string) (*Manifest, error) {
+	m := &Manifest{path: path}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		err = json.Unmarshal(scanner.Bytes(), &m.manifestHeader)
+		if err != nil {
+			// a corrupt manifest only costs us the resume state, not the download
+			log.Printf("[loadManifest] ignoring unreadable manifest <%s>: %s\n", path, err)
+			return &Manifest{path: path}, nil
+		}
+	}
+	for scanner.Scan() {
+		var e ManifestEntry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// most likely a line cut short by a crash; everything before it is good
+			log.Printf("[loadManifest] stopping at unreadable entry: %s\n", err)
+			break
+		}
+		m.entries = append(m.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[loadManifest] loaded manifest with %d completed chunks\n", len(m.entries))
+	return m, nil
+}
+
+// ResumePoint returns how many chunks from the start of chunks were already
+// appended to an output file of outSize bytes, and the size of those chunks.
+// Entries that don't line up with chunks or don't fit in the file are ignored.
+func (m *Manifest) ResumePoint(chunks []Chunk, outSize int64) (int, int64) {
+	var offset int64
+	n := 0
+	for _, e := range m.entries {
+		if n >= len(chunks) || e.Index != n || e.Name != chunks[n].Name || offset+e.Size > outSize {
+			break
+		}
+		offset += e.Size
+		n++
+	}
+	return n, offset
+}
+
+// Entry returns the entry for the i-th appended chunk
+func (m *Manifest) Entry(i int) ManifestEntry {
+	return m.entries[i]
+}
+
+// Len returns how many chunks the manifest has entries for
+func (m *Manifest) Len() int {
+	return len(m.entries)
+}
+
+// Reset keeps only the first n entries, points the manifest at output, and
+// rewrites it to disk
+func (m *Manifest) Reset(n int, output string) error {
+	if n < len(m.entries) {
+		m.entries = m.entries[:n]
+	}
+	m.Output = output
+
+	err := m.Close()
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(m.manifestHeader)
+	for i := 0; err == nil && i < len(m.entries); i++ {
+		err = enc.Encode(m.entries[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename it into place so that a crash never
+	// leaves a half-written manifest behind
+	return os.Rename(tmp, m.path)
+}
+
+// Append records a chunk as appended to the output file
+func (m *Manifest) Append(e ManifestEntry) error {
+	if m.f == nil {
+		f, err := os.OpenFile(m.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		m.f = f
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = m.f.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	m.entries = append(m.entries, e)
+
+	return nil
+}
+
+// Close closes the manifest file if it is open for appending
+func (m *Manifest) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	err := m.f.Close()
+	m.f = nil
+	return err
+}
+
+// ChunkIndexFile is the name of the playlist of kept chunks written to the
+// work directory in keep-chunks mode
+const ChunkIndexFile = "index.m3u8"
+
+// chunkFileName names a kept chunk after its position in the download so the
+// files sort in playlist order
+func chunkFileName(c Chunk) string {
+	ext := path.Ext(c.URL.Path)
+	if ext == "" {
+		ext = ".ts"
+	}
+	return fmt.Sprintf("%06d%s", c.Index, ext)
+}
+
+// writeChunkIndex writes a media playlist of the kept chunk files so they can
+// be inspected or re-muxed (e.g. with "ffmpeg -i index.m3u8 -c copy out.mp4").
+// Chunks that were skipped as gaps are left out, as are ones without a file,
+// which a run resumed from one without keep-chunks never fetched; it returns
+// how many of those there were.
+func writeChunkIndex(workDir string, chunks []Chunk, gaps []Gap) (int, error) {
+	skipped := make(map[int]bool, len(gaps))
+	for _, g := range gaps {
+		skipped[g.Index] = true
+	}
+
+	target := 0.0
+	version := 3
+	for _, c := range chunks {
+		if c.Length > target {
+			target = c.Length
+		}
+		if c.Init {
+			// EXT-X-MAP needs version 6
+			version = 6
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", version, int(math.Ceil(target)))
+	// the timestamps jump over a gap, so the segment after it is marked as
+	// a discontinuity for players to reset on
+	discontinuity := false
+	missing := 0
+	for _, c := range chunks {
+		if skipped[c.Index] {
+			fmt.Fprintf(&b, "# gap: %s could not be downloaded\n", c.Name)
+			discontinuity = true
+			continue
+		}
+		if _, err := os.Stat(c.Path); err != nil {
+			fmt.Fprintf(&b, "# missing: %s was not kept\n", c.Name)
+			missing++
+			discontinuity = true
+			continue
+		}
+		if discontinuity || (c.Init && c.Index > 0) {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+			discontinuity = false
+		}
+		if c.Init {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", filepath.Base(c.Path))
+			continue
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", c.Length, filepath.Base(c.Path))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	return missing, ioutil.WriteFile(filepath.Join(workDir, ChunkIndexFile), []byte(b.String()), 0644)
+}
+
+// removeWorkDir deletes a finished download's work directory
+func removeWorkDir(workDir string) {
+	fmt.Println("Cleaning up temp files")
+	err := os.RemoveAll(workDir)
+	if err != nil {
+		fmt.Printf("Failed to remove work dir <%s>\n", workDir)
+		log.Println(err)
+	}
+}
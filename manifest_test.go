@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEntry records data as the chunk at index i of testChunks
+func testEntry(i int, data []byte) ManifestEntry {
+	h := sha256.Sum256(data)
+	return ManifestEntry{Index: i, Name: fmt.Sprintf("%d.ts", i), Size: int64(len(data)), Hash: hex.EncodeToString(h[:])}
+}
+
+// writeTestManifest writes a manifest with the given entries to dir
+func writeTestManifest(t *testing.T, dir string, header manifestHeader, entries ...ManifestEntry) *Manifest {
+	t.Helper()
+	m := &Manifest{manifestHeader: header, path: filepath.Join(dir, ManifestFile)}
+	err := m.Reset(0, header.Output)
+	for i := 0; err == nil && i < len(entries); i++ {
+		err = m.Append(entries[i])
+	}
+	if err == nil {
+		err = m.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestManifestResumePoint(t *testing.T) {
+	chunks := testChunks(10, 10, 10)
+	entries := []ManifestEntry{
+		{Index: 0, Name: "0.ts", Size: 100},
+		{Index: 1, Name: "1.ts", Size: 200},
+		{Index: 2, Name: "2.ts", Size: 300},
+	}
+	renamed := append([]ManifestEntry(nil), entries...)
+	renamed[1].Name = "other.ts"
+	skipped := []ManifestEntry{entries[0], entries[2]}
+
+	tests := []struct {
+		name       string
+		entries    []ManifestEntry
+		chunks     []Chunk
+		outSize    int64
+		wantNext   int
+		wantOffset int64
+	}{
+		{"everything written", entries, chunks, 600, 3, 600},
+		{"extra bytes from a cut-off write", entries, chunks, 650, 3, 600},
+		{"output smaller than recorded", entries, chunks, 450, 2, 300},
+		{"empty output", entries, chunks, 0, 0, 0},
+		{"mismatched name", renamed, chunks, 600, 1, 100},
+		{"entries out of order", skipped, chunks, 600, 1, 100},
+		{"fewer chunks than entries", entries, chunks[:2], 600, 2, 300},
+		{"empty manifest", nil, chunks, 600, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manifest{entries: tt.entries}
+			next, offset := m.ResumePoint(tt.chunks, tt.outSize)
+			if next != tt.wantNext || offset != tt.wantOffset {
+				t.Errorf("ResumePoint() = %d, %d, want %d, %d", next, offset, tt.wantNext, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	header := `{"ID":"123","Variant":"720p60-0123abcd","StartSec":0,"EndSec":-1,"Output":"out.ts.part"}`
+	entry0 := `{"Index":0,"Name":"0.ts","Size":100,"Hash":"aa"}`
+	entry1 := `{"Index":1,"Name":"1.ts","Size":200,"Hash":"bb"}`
+
+	tests := []struct {
+		name        string
+		content     *string
+		wantID      string
+		wantEntries int
+	}{
+		{"missing", nil, "", 0},
+		{"header only", strPtr(header + "\n"), "123", 0},
+		{"complete", strPtr(header + "\n" + entry0 + "\n" + entry1 + "\n"), "123", 2},
+		{"cut-off trailing line", strPtr(header + "\n" + entry0 + "\n" + entry1[:20]), "123", 1},
+		{"unreadable line in the middle", strPtr(header + "\n" + entry0 + "\n{\n" + entry1 + "\n"), "123", 1},
+		{"corrupt header", strPtr(header[:30] + "\n" + entry0 + "\n"), "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ManifestFile)
+			if tt.content != nil {
+				err := ioutil.WriteFile(path, []byte(*tt.content), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m, err := loadManifest(path)
+			if err != nil {
+				t.Fatalf("loadManifest() error = %v", err)
+			}
+			if m.ID != tt.wantID || m.Len() != tt.wantEntries {
+				t.Errorf("loadManifest() = ID %q with %d entries, want ID %q with %d entries", m.ID, m.Len(), tt.wantID, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestManifestReset(t *testing.T) {
+	dir := t.TempDir()
+	header := manifestHeader{ID: "123", Variant: "720p60-0123abcd", StartSec: 10, EndSec: 20, Output: "old.ts.part"}
+	m := writeTestManifest(t, dir, header, testEntry(0, []byte("a")), testEntry(1, []byte("b")), testEntry(2, []byte("c")))
+
+	err := m.Reset(2, "new.ts.part")
+	if err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if _, err := os.Stat(m.path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Reset() left its temp file behind")
+	}
+
+	loaded, err := loadManifest(m.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Output = "new.ts.part"
+	if loaded.manifestHeader != header {
+		t.Errorf("Reset() wrote header %+v, want %+v", loaded.manifestHeader, header)
+	}
+	if loaded.Len() != 2 || loaded.Entry(1).Name != "1.ts" {
+		t.Errorf("Reset() kept %d entries, want the first 2", loaded.Len())
+	}
+}
+
+func TestPrepareWorkDir(t *testing.T) {
+	tests := []struct {
+		name        string
+		variant     string
+		startSec    int
+		wantEntries int
+	}{
+		{"same download", "720p60-0123abcd", 0, 1},
+		{"another rendition under the same name", "720p60-4567ef01", 0, 0},
+		{"another range under the same name", "720p60-0123abcd", 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			// the work dir is named as for the first download, as if the
+			// names of both sanitized to the same one
+			dir := filepath.Join(base, workDirName("123", "720p60-0123abcd", 0, -1))
+			err := os.MkdirAll(dir, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestManifest(t, dir, manifestHeader{ID: "123", Variant: "720p60-0123abcd", EndSec: -1}, testEntry(0, []byte("a")))
+			if other := filepath.Join(base, workDirName("123", tt.variant, tt.startSec, -1)); other != dir {
+				err = os.Rename(dir, other)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, m, err := prepareWorkDir(base, "123", tt.variant, tt.startSec, -1)
+			if err != nil {
+				t.Fatalf("prepareWorkDir() error = %v", err)
+			}
+			if m.Len() != tt.wantEntries {
+				t.Errorf("prepareWorkDir() loaded %d entries, want %d", m.Len(), tt.wantEntries)
+			}
+			if m.Variant != tt.variant || m.StartSec != tt.startSec {
+				t.Errorf("prepareWorkDir() header = %+v, want variant %q from %d", m.manifestHeader, tt.variant, tt.startSec)
+			}
+		})
+	}
+}
+
+func TestNewAssemblerResume(t *testing.T) {
+	data := [][]byte{bytes.Repeat([]byte{1}, 100), bytes.Repeat([]byte{2}, 200), bytes.Repeat([]byte{3}, 300)}
+	entries := []ManifestEntry{testEntry(0, data[0]), testEntry(1, data[1]), testEntry(2, data[2])}
+	written := bytes.Join(data, nil)
+
+	failed := testEntry(1, nil)
+	failed.Error = "error: got HTTP 404"
+
+	tests := []struct {
+		name     string
+		entries  []ManifestEntry
+		part     []byte
+		wantNext int
+		wantGaps int
+	}{
+		{"nothing written", nil, nil, 0, 0},
+		{"everything written", entries, written, 3, 0},
+		{"cut-off write after the last chunk", entries[:2], written[:450], 2, 0},
+		{"output smaller than recorded", entries, written[:350], 2, 0},
+		{"tail hash mismatch", entries[:2], append(append([]byte{}, data[0]...), data[2][:200]...), 0, 0},
+		{"partial output of another download", entries, bytes.Repeat([]byte{9}, 600), 0, 0},
+		{"gap", []ManifestEntry{entries[0], failed, testEntry(2, data[2])}, append(append([]byte{}, data[0]...), data[2]...), 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outPath := filepath.Join(dir, "out.ts")
+			if tt.part != nil {
+				err := ioutil.WriteFile(outPath+".part", tt.part, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := writeTestManifest(t, dir, manifestHeader{ID: "123", Output: outPath + ".part"}, tt.entries...)
+
+			a, err := newAssembler(testChunks(10, 10, 10), m, outPath)
+			if err != nil {
+				t.Fatalf("newAssembler() error = %v", err)
+			}
+			defer a.Close()
+
+			if a.Next() != tt.wantNext {
+				t.Errorf("newAssembler() resumes at chunk %d, want %d", a.Next(), tt.wantNext)
+			}
+			if len(a.Gaps()) != tt.wantGaps {
+				t.Errorf("newAssembler() found %d gaps, want %d", len(a.Gaps()), tt.wantGaps)
+			}
+
+			// everything after the resume point is truncated away
+			var want []byte
+			for i := 0; i < tt.wantNext; i++ {
+				if tt.entries[i].Error == "" {
+					want = append(want, data[i]...)
+				}
+			}
+			got, err := ioutil.ReadFile(outPath + ".part")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("partial output is %d bytes, want %d", len(got), len(want))
+			}
+			if m.Len() != tt.wantNext {
+				t.Errorf("manifest kept %d entries, want %d", m.Len(), tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestNewAssemblerMovesPreviousOutput(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte{1}, 100)
+	oldPart := filepath.Join(dir, "old.ts.part")
+	err := ioutil.WriteFile(oldPart, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := writeTestManifest(t, dir, manifestHeader{ID: "123", Output: oldPart}, testEntry(0, data))
+
+	outPath := filepath.Join(dir, "new.ts")
+	a, err := newAssembler(testChunks(10, 10), m, outPath)
+	if err != nil {
+		t.Fatalf("newAssembler() error = %v", err)
+	}
+	defer a.Close()
+
+	if a.Next() != 1 {
+		t.Errorf("newAssembler() resumes at chunk %d, want 1", a.Next())
+	}
+	if _, err := os.Stat(oldPart); !os.IsNotExist(err) {
+		t.Errorf("previous partial output <%s> was not moved", oldPart)
+	}
+	if m.Output != outPath+".part" {
+		t.Errorf("manifest points at <%s>, want <%s>", m.Output, outPath+".part")
+	}
+}
+
+func TestWriteChunkIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		lengths     []float64
+		init        bool
+		gaps        []Gap
+		missing     []int
+		want        string
+		wantMissing int
+	}{
+		{
+			name:    "every chunk kept",
+			lengths: []float64{10, 10, 5.5},
+			want:    "#EXTINF:10.000,\n000000.ts\n#EXTINF:10.000,\n000001.ts\n#EXTINF:5.500,\n000002.ts\n",
+		},
+		{
+			name:    "gap",
+			lengths: []float64{10, 10, 10},
+			gaps:    []Gap{{Index: 1}},
+			want:    "#EXTINF:10.000,\n000000.ts\n# gap: 1.ts could not be downloaded\n#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n000002.ts\n",
+		},
+		{
+			name:    "adjacent gaps",
+			lengths: []float64{10, 10, 10, 10},
+			gaps:    []Gap{{Index: 1}, {Index: 2}},
+			want:    "#EXTINF:10.000,\n000000.ts\n# gap: 1.ts could not be downloaded\n# gap: 2.ts could not be downloaded\n#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n000003.ts\n",
+		},
+		{
+			name:        "files missing from an earlier run",
+			lengths:     []float64{10, 10, 10},
+			missing:     []int{0, 1},
+			want:        "# missing: 0.ts was not kept\n# missing: 1.ts was not kept\n#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n000002.ts\n",
+			wantMissing: 2,
+		},
+		{
+			name:        "gap after a missing file",
+			lengths:     []float64{10, 10, 10},
+			gaps:        []Gap{{Index: 1}},
+			missing:     []int{0},
+			want:        "# missing: 0.ts was not kept\n# gap: 1.ts could not be downloaded\n#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n000002.ts\n",
+			wantMissing: 1,
+		},
+		{
+			name:    "init segment",
+			lengths: []float64{0, 10, 10},
+			init:    true,
+			want:    "#EXT-X-MAP:URI=\"000000.ts\"\n#EXTINF:10.000,\n000001.ts\n#EXTINF:10.000,\n000002.ts\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			chunks := testChunks(tt.lengths...)
+			missing := make(map[int]bool, len(tt.missing))
+			for _, i := range tt.missing {
+				missing[i] = true
+			}
+			skipped := make(map[int]bool, len(tt.gaps))
+			for _, g := range tt.gaps {
+				skipped[g.Index] = true
+			}
+			for i := range chunks {
+				chunks[i].URL = &url.URL{Path: "/" + chunks[i].Name}
+				chunks[i].Path = filepath.Join(dir, chunkFileName(chunks[i]))
+				if !missing[i] && !skipped[i] {
+					err := ioutil.WriteFile(chunks[i].Path, []byte{1}, 0644)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			chunks[0].Init = tt.init
+
+			n, err := writeChunkIndex(dir, chunks, tt.gaps)
+			if err != nil {
+				t.Fatalf("writeChunkIndex() error = %v", err)
+			}
+			if n != tt.wantMissing {
+				t.Errorf("writeChunkIndex() = %d missing, want %d", n, tt.wantMissing)
+			}
+
+			got, err := ioutil.ReadFile(filepath.Join(dir, ChunkIndexFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// everything after the header
+			body := string(got)
+			body = body[strings.Index(body, "#EXT-X-PLAYLIST-TYPE:VOD\n")+len("#EXT-X-PLAYLIST-TYPE:VOD\n"):]
+			if want := tt.want + "#EXT-X-ENDLIST\n"; body != want {
+				t.Errorf("writeChunkIndex() wrote\n%s\nwant\n%s", body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Policies for Twitch's DMCA-muted chunks
+const (
+	// MutedKeep downloads muted chunks as they are
+	MutedKeep = "keep"
+	// MutedUnmute tries the original (unmuted) chunk first and falls back to
+	// the muted one
+	MutedUnmute = "unmute"
+	// MutedSkip leaves muted chunks out of the output
+	MutedSkip = "skip"
+)
+
+const mutedSuffix = "-muted.ts"
+
+// isMutedURI reports whether a chunk URI is one Twitch has replaced with a
+// muted copy (e.g. "123-muted.ts")
+func isMutedURI(u *url.URL) bool {
+	return strings.HasSuffix(u.Path, mutedSuffix)
+}
+
+// unmutedURL returns the URL of the original chunk for a muted chunk URL
+func unmutedURL(u *url.URL) *url.URL {
+	unmuted := *u
+	unmuted.Path = strings.TrimSuffix(u.Path, mutedSuffix) + ".ts"
+	unmuted.RawPath = ""
+	return &unmuted
+}
+
+// MutedRange is a run of consecutive muted chunks
+type MutedRange struct {
+	// Start and End are offsets in seconds from the start of the VOD
+	Start float64
+	End   float64
+	// Chunks is the number of chunks in the range
+	Chunks int
+}
+
+// mutedRanges collects consecutive muted chunks into ranges
+func mutedRanges(chunks []Chunk) []MutedRange {
+	var ranges []MutedRange
+	for i, c := range chunks {
+		if !c.Muted {
+			continue
+		}
+		end := c.Start + c.Length
+		if n := len(ranges); n > 0 && i > 0 && chunks[i-1].Muted {
+			ranges[n-1].End = end
+			ranges[n-1].Chunks++
+			continue
+		}
+		ranges = append(ranges, MutedRange{Start: c.Start, End: end, Chunks: 1})
+	}
+	return ranges
+}
+
+// reportMutedRanges prints and logs the muted parts of the download
+func reportMutedRanges(ranges []MutedRange, policy string) {
+	total := 0.0
+	for _, r := range ranges {
+		total += r.End - r.Start
+	}
+
+	action := map[string]string{
+		MutedKeep:   "will be downloaded muted",
+		MutedUnmute: "will be tried unmuted first",
+		MutedSkip:   "will be skipped",
+	}[policy]
+	fmt.Printf("Found %d muted range(s) totalling %s, which %s:\n", len(ranges), secondsToTimeMask(int(total)), action)
+	for _, r := range ranges {
+		line := fmt.Sprintf("  %s - %s (%d chunks)", secondsToTimeMask(int(r.Start)), secondsToTimeMask(int(r.End)), r.Chunks)
+		fmt.Println(line)
+		log.Println("muted:" + line)
+	}
+}
+
+// writeMutedReport saves the muted ranges next to the output file as
+// <outFile>.muted.json
+func writeMutedReport(outFile string, ranges []MutedRange, policy string) error {
+	report := struct {
+		Policy string
+		Ranges []MutedRange
+	}{policy, ranges}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outFile+".muted.json", data, 0644)
+}
+
+// skipMutedChunks drops muted chunks and renumbers the rest
+func skipMutedChunks(chunks []Chunk) []Chunk {
+	kept := chunks[:0]
+	for _, c := range chunks {
+		if !c.Muted {
+			c.Index = len(kept)
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
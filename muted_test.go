@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestUnmutedURL(t *testing.T) {
+	tests := []struct {
+		uri       string
+		wantMuted bool
+		want      string
+	}{
+		{"https://cdn.example.com/vod/chunked/123-muted.ts", true, "https://cdn.example.com/vod/chunked/123.ts"},
+		{"https://cdn.example.com/vod/chunked/123-muted.ts?token=abc", true, "https://cdn.example.com/vod/chunked/123.ts?token=abc"},
+		{"https://cdn.example.com/vod/chunked/a%20b-muted.ts", true, "https://cdn.example.com/vod/chunked/a%20b.ts"},
+		{"https://cdn.example.com/vod/chunked/123.ts", false, ""},
+		{"https://cdn.example.com/vod/chunked/123-unmuted.ts", false, ""},
+		{"https://cdn.example.com/vod/chunked/123.ts?muted=-muted.ts", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			u, err := url.Parse(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isMutedURI(u); got != tt.wantMuted {
+				t.Fatalf("isMutedURI() = %v, want %v", got, tt.wantMuted)
+			}
+			if !tt.wantMuted {
+				return
+			}
+			if got := unmutedURL(u).String(); got != tt.want {
+				t.Errorf("unmutedURL() = %s, want %s", got, tt.want)
+			}
+			if u.String() != tt.uri {
+				t.Errorf("unmutedURL() changed its argument to %s", u)
+			}
+		})
+	}
+}
+
+// testMutedChunks builds 10-second chunks, muting the ones at the given
+// positions
+func testMutedChunks(n int, muted ...int) []Chunk {
+	lengths := make([]float64, n)
+	for i := range lengths {
+		lengths[i] = 10
+	}
+	chunks := testChunks(lengths...)
+	for _, i := range muted {
+		chunks[i].Muted = true
+	}
+	return chunks
+}
+
+func TestMutedRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []Chunk
+		want   []MutedRange
+	}{
+		{"nothing muted", testMutedChunks(4), nil},
+		{"one chunk", testMutedChunks(4, 1), []MutedRange{{Start: 10, End: 20, Chunks: 1}}},
+		{"consecutive chunks", testMutedChunks(5, 1, 2, 3), []MutedRange{{Start: 10, End: 40, Chunks: 3}}},
+		{"separate ranges", testMutedChunks(6, 0, 1, 4), []MutedRange{{Start: 0, End: 20, Chunks: 2}, {Start: 40, End: 50, Chunks: 1}}},
+		{"up to the end", testMutedChunks(3, 1, 2), []MutedRange{{Start: 10, End: 30, Chunks: 2}}},
+		{"everything", testMutedChunks(2, 0, 1), []MutedRange{{Start: 0, End: 20, Chunks: 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mutedRanges(tt.chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mutedRanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipMutedChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []Chunk
+		wantNames []string
+	}{
+		{"nothing muted", testMutedChunks(3), []string{"0.ts", "1.ts", "2.ts"}},
+		{"muted in the middle", testMutedChunks(4, 1, 2), []string{"0.ts", "3.ts"}},
+		{"muted at the ends", testMutedChunks(4, 0, 3), []string{"1.ts", "2.ts"}},
+		{"everything", testMutedChunks(2, 0, 1), []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := skipMutedChunks(tt.chunks)
+			names := []string{}
+			for i, c := range got {
+				names = append(names, c.Name)
+				if c.Index != i {
+					t.Errorf("chunk %s has index %d, want %d", c.Name, c.Index, i)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("skipMutedChunks() kept %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quality names with a special meaning
+const (
+	BestQuality  = "best"
+	WorstQuality = "worst"
+)
+
+// maxHeightRegex matches a resolution limit such as "<=720p"
+var maxHeightRegex = regexp.MustCompile(`^<=(\d{3,4})p$`)
+
+// codecSuffixRegex matches a quality name that asks for a codec, such as
+// "1080p60-hevc"
+var codecSuffixRegex = regexp.MustCompile(`^(.+)-(avc|hevc|av1)$`)
+
+// splitQuality splits a Quality preference list such as "1080p60,720p60,best"
+func splitQuality(quality string) []string {
+	var prefs []string
+	for _, p := range strings.Split(quality, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefs = append(prefs, p)
+		}
+	}
+	return prefs
+}
+
+// videoVariants returns the variants with video, smallest first
+func videoVariants(variants []*Variant) []*Variant {
+	var res []*Variant
+	for _, v := range variants {
+		if !v.AudioOnly {
+			res = append(res, v)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Bandwidth < res[j].Bandwidth
+	})
+	return res
+}
+
+// matchQuality looks up a single quality preference, returning the matching
+// variant and why it matched, or nil if nothing does
+func matchQuality(variants []*Variant, pref string) (*Variant, string) {
+	pref = strings.ToLower(pref)
+	video := videoVariants(variants)
+
+	switch {
+	case pref == BestQuality:
+		if len(video) > 0 {
+			return video[len(video)-1], "highest bandwidth"
+		}
+		return nil, ""
+	case pref == WorstQuality:
+		if len(video) > 0 {
+			return video[0], "lowest bandwidth"
+		}
+		return nil, ""
+	}
+
+	if m := maxHeightRegex.FindStringSubmatch(pref); m != nil {
+		limit, _ := strconv.Atoi(m[1])
+		for i := len(video) - 1; i >= 0; i-- {
+			if h := video[i].Height; h > 0 && h <= limit {
+				return video[i], fmt.Sprintf("highest bandwidth at %dp or below", limit)
+			}
+		}
+		return nil, ""
+	}
+
+	name, codec := pref, ""
+	if m := codecSuffixRegex.FindStringSubmatch(pref); m != nil {
+		name, codec = m[1], m[2]
+	}
+
+	// several variants can share a name (e.g. "720p" for 720p30 and 720p60,
+	// or an H.264 and an HEVC 1080p60), so H.264 is preferred unless another
+	// codec was asked for, and then the highest bandwidth
+	var best *Variant
+	for _, v := range variants {
+		if codec != "" && v.Codec() != codec {
+			continue
+		}
+		if !containsString(v.Names(), name) {
+			continue
+		}
+		if best == nil || betterMatch(v, best) {
+			best = v
+		}
+	}
+	if best == nil {
+		return nil, ""
+	}
+	if codec != "" {
+		return best, fmt.Sprintf("name match with %s video", codec)
+	}
+	return best, "name match"
+}
+
+// betterMatch reports whether v is a better pick than other for a name that
+// matches both
+func betterMatch(v, other *Variant) bool {
+	vAVC, otherAVC := v.Codec() != "hevc" && v.Codec() != "av1", other.Codec() != "hevc" && other.Codec() != "av1"
+	if vAVC != otherAVC {
+		return vAVC
+	}
+	return v.Bandwidth > other.Bandwidth
+}
+
+// variantNames lists the names of the variants for error messages
+func variantNames(variants []*Variant) []string {
+	names := make([]string, 0, len(variants))
+	for _, v := range variants {
+		name := v.Name
+		if c := v.Codec(); c == "hevc" || c == "av1" {
+			name += " (" + c + ")"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
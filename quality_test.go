@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+// testVariants is a master playlist like Twitch's, with an HEVC copy of the
+// source quality
+var testVariants = []*Variant{
+	{Name: "1080p60 (source)", Group: "chunked", Resolution: "1920x1080", Width: 1920, Height: 1080, FrameRate: 60, Bandwidth: 8000000, Codecs: "avc1.64002A,mp4a.40.2"},
+	{Name: "1080p60", Group: "1080p60_hevc", Resolution: "1920x1080", Width: 1920, Height: 1080, FrameRate: 60, Bandwidth: 6000000, Codecs: "hvc1.1.6.L120.90,mp4a.40.2"},
+	{Name: "720p60", Group: "720p60", Resolution: "1280x720", Width: 1280, Height: 720, FrameRate: 60, Bandwidth: 3400000, Codecs: "avc1.4D401F,mp4a.40.2"},
+	{Name: "720p30", Group: "720p30", Resolution: "1280x720", Width: 1280, Height: 720, FrameRate: 30, Bandwidth: 2300000, Codecs: "avc1.4D401F,mp4a.40.2"},
+	{Name: "480p30", Group: "480p30", Resolution: "852x480", Width: 852, Height: 480, FrameRate: 30, Bandwidth: 1400000, Codecs: "avc1.4D401E,mp4a.40.2"},
+	{Name: "160p30", Group: "160p30", Resolution: "284x160", Width: 284, Height: 160, FrameRate: 30, Bandwidth: 230000, Codecs: "avc1.4D400C,mp4a.40.2"},
+	{Name: "Audio Only", Group: audioOnlyGroup, Bandwidth: 160000, Codecs: "mp4a.40.2", AudioOnly: true},
+}
+
+func TestMatchQuality(t *testing.T) {
+	tests := []struct {
+		pref string
+		want string
+	}{
+		{"best", "1080p60 (source)"},
+		{"BEST", "1080p60 (source)"},
+		{"worst", "160p30"},
+		{"<=720p", "720p60"},
+		{"<=1000p", "720p60"},
+		{"<=160p", "160p30"},
+		{"<=100p", ""},
+		{"1080p60", "1080p60 (source)"},
+		{"1080p60 (source)", "1080p60 (source)"},
+		{"1080p60 (Source)", "1080p60 (source)"},
+		{"chunked", "1080p60 (source)"},
+		{"1080p", "1080p60 (source)"},
+		{"1080p60-hevc", "1080p60"},
+		{"1080p60-avc", "1080p60 (source)"},
+		{"1080p60-av1", ""},
+		{"720p", "720p60"},
+		{"720P30", "720p30"},
+		{"1280x720", "720p60"},
+		{"Audio Only", "Audio Only"},
+		{"audio_only", "Audio Only"},
+		{"audio", "Audio Only"},
+		{"360p", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pref, func(t *testing.T) {
+			got, reason := matchQuality(testVariants, tt.pref)
+			name := ""
+			if got != nil {
+				name = got.Name
+			}
+			if name != tt.want {
+				t.Errorf("matchQuality(%q) = %q, want %q", tt.pref, name, tt.want)
+			}
+			if (got != nil) != (reason != "") {
+				t.Errorf("matchQuality(%q) reason = %q for variant %v", tt.pref, reason, got)
+			}
+		})
+	}
+}
+
+func TestMatchQualityNoVideo(t *testing.T) {
+	audioOnly := []*Variant{testVariants[len(testVariants)-1]}
+	for _, pref := range []string{"best", "worst", "<=720p"} {
+		if got, _ := matchQuality(audioOnly, pref); got != nil {
+			t.Errorf("matchQuality(%q) = %v, want no match without video", pref, got)
+		}
+	}
+}
+
+func TestSplitQuality(t *testing.T) {
+	tests := []struct {
+		quality string
+		want    []string
+	}{
+		{"best", []string{"best"}},
+		{"1080p60, 720p60 ,best", []string{"1080p60", "720p60", "best"}},
+		{" , ,worst,", []string{"worst"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.quality, func(t *testing.T) {
+			got := splitQuality(tt.quality)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitQuality(%q) = %q, want %q", tt.quality, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("splitQuality(%q) = %q, want %q", tt.quality, got, tt.want)
+				}
+			}
+		})
+	}
+}
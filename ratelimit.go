@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitReadSize caps a single read so one worker can't take the whole
+// bucket at once
+const rateLimitReadSize = 32 * 1024
+
+// RateLimiter is a token bucket shared by every download worker so that total
+// throughput stays under the limit regardless of the number of workers
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSec bytes per second
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	burst := float64(bytesPerSec)
+	if burst < rateLimitReadSize {
+		burst = rateLimitReadSize
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// WaitN takes n bytes from the bucket, blocking until the bucket has refilled
+// enough to cover them or ctx is cancelled
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// tokens may go negative, which makes later callers wait their turn
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Reader wraps r so that reads from it are throttled by the limiter
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &rateLimitedReader{ctx: ctx, r: r, l: l}
+}
+
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimitReadSize {
+		p = p[:rateLimitReadSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		waitErr := r.l.WaitN(r.ctx, n)
+		if waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// parseRate converts a rate such as "5M", "500K", "1.5m", or "65536" into bytes
+// per second; suffixes are powers of 1024
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("error: rate must not be empty")
+	}
+
+	num := s
+	multiplier := 1.0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1024
+	case "M":
+		multiplier = 1024 * 1024
+	case "G":
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		num = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || !(v > 0) || math.IsInf(v, 1) {
+		return 0, fmt.Errorf("error: rate must be a positive number optionally followed by K, M, or G; got '%s'", s)
+	}
+
+	// a fraction of a byte would be truncated to no limit at all
+	rate := v * multiplier
+	if rate < 1 {
+		return 0, fmt.Errorf("error: rate must be at least 1 byte per second; got '%s'", s)
+	}
+	if rate >= math.MaxInt64 {
+		return 0, fmt.Errorf("error: rate '%s' is too large", s)
+	}
+
+	return int64(rate), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    int64
+		wantErr bool
+	}{
+		{"65536", 65536, false},
+		{"500K", 500 * 1024, false},
+		{"500k", 500 * 1024, false},
+		{"5M", 5 * 1024 * 1024, false},
+		{"1.5m", 1536 * 1024, false},
+		{"2G", 2 * 1024 * 1024 * 1024, false},
+		{" 10K ", 10 * 1024, false},
+		{"1", 1, false},
+		{"1.9", 1, false},
+		{"0.5K", 512, false},
+		{"0.001K", 1, false},
+		{"", 0, true},
+		{"K", 0, true},
+		{"0", 0, true},
+		{"0.5", 0, true},
+		{"0.9", 0, true},
+		{"0.0001K", 0, true},
+		{"-5M", 0, true},
+		{"5T", 0, true},
+		{"fast", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"1e30G", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rate, func(t *testing.T) {
+			got, err := parseRate(tt.rate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRate(%q) error = %v, wantErr %v", tt.rate, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRate(%q) = %d, want %d", tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWaitN(t *testing.T) {
+	l := NewRateLimiter(1024 * 1024)
+
+	// the first second's worth is available straight away
+	start := time.Now()
+	err := l.WaitN(context.Background(), 1024*1024)
+	if err != nil {
+		t.Fatalf("WaitN() error = %v", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("WaitN() within the burst took %s", d)
+	}
+
+	// the bucket is empty now, so another second's worth has to wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = l.WaitN(ctx, 1024*1024)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitN() on an empty bucket error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRateLimiterReader(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 3*rateLimitReadSize)
+	l := NewRateLimiter(1024 * 1024)
+
+	got, err := ioutil.ReadAll(l.Reader(context.Background(), bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes through the limiter, want %d", len(got), len(data))
+	}
+}
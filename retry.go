@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how many times a chunk download is attempted and how
+// long to wait between attempts
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Delay returns the wait before the given retry (1 for the first retry),
+// doubling each time up to MaxDelay and randomized to between half and all
+// of that value so that workers don't retry in lockstep
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := int64(d / 2)
+	jitterMu.Lock()
+	j := jitterRand.Int63n(half + 1)
+	jitterMu.Unlock()
+	return time.Duration(half + j)
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// HTTPStatusError is returned when a server responds with a non-2xx status
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("error: got HTTP %d %s for <%s>", e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
+
+// isRetryable reports whether an error is likely transient (timeouts, server
+// errors, dropped connections, short reads, mangled payloads) as opposed to
+// fatal (e.g. a 403 from an expired token, a 404 for a missing chunk, or a
+// chunk that can't be decrypted)
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusRequestTimeout
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return !validationErr.Permanent
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return true
+	}
+
+	// a name that doesn't exist won't start existing on a retry, but a
+	// resolver that timed out or failed may recover
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	// every error from client.Do is a *url.Error, which is a net.Error, so
+	// only timeouts are retried here; certificate and TLS failures,
+	// unsupported schemes, and proxy errors are fatal
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"HTTP 500", &HTTPStatusError{StatusCode: 500}, true},
+		{"HTTP 503", &HTTPStatusError{StatusCode: 503}, true},
+		{"HTTP 429", &HTTPStatusError{StatusCode: 429}, true},
+		{"HTTP 408", &HTTPStatusError{StatusCode: 408}, true},
+		{"HTTP 403", &HTTPStatusError{StatusCode: 403}, false},
+		{"HTTP 404", &HTTPStatusError{StatusCode: 404}, false},
+		{"wrapped HTTP 502", fmt.Errorf("error: chunk 1.ts: %w", &HTTPStatusError{StatusCode: 502}), true},
+		{"missing TS sync byte", &ValidationError{Chunk: "1.ts", Reason: "missing MPEG-TS sync byte at offset 0 (got 0x3c)"}, true},
+		{"empty payload", &ValidationError{Chunk: "1.ts", Reason: "empty payload"}, true},
+		{"bad padding after decryption", &ValidationError{Chunk: "1.ts", Reason: "invalid padding after decryption (wrong key or IV?)", Permanent: true}, false},
+		{"no audio in chunk", &ValidationError{Chunk: "1.ts", Reason: "no audio stream in chunk", Permanent: true}, false},
+		{"short read", fmt.Errorf("error: chunk 1.ts short read, got 10 of 20 bytes: %w", io.ErrUnexpectedEOF), true},
+		{"EOF", io.EOF, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"DNS timeout", &net.DNSError{Name: "example.com", IsTimeout: true}, true},
+		{"no such host", &net.DNSError{Name: "example.com", Err: "no such host", IsNotFound: true}, false},
+		{"client timeout", &url.Error{Op: "Get", URL: "https://example.com/1.ts", Err: timeoutError{}}, true},
+		{"unsupported scheme", &url.Error{Op: "Get", URL: "ftp://example.com/1.ts", Err: errors.New("unsupported protocol scheme")}, false},
+		{"cancelled", context.Canceled, false},
+		{"other", errors.New("error: something else"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+		want   time.Duration // the delay before jitter
+	}{
+		{"first retry", p, 1, time.Second},
+		{"second retry", p, 2, 2 * time.Second},
+		{"fourth retry", p, 4, 8 * time.Second},
+		{"capped", p, 5, 10 * time.Second},
+		{"long after the cap", p, 50, 10 * time.Second},
+		{"base above the cap", RetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Second}, 1, 10 * time.Second},
+		{"no delay", RetryPolicy{}, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the jitter is random, so check the bounds over many draws
+			for i := 0; i < 1000; i++ {
+				got := tt.policy.Delay(tt.retry)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("Delay(%d) = %s, want between %s and %s", tt.retry, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 func isValidFilename(fn string) bool {
@@ -47,10 +48,28 @@ func isValidFilename(fn string) bool {
 
 // Chunk represents a video chunk from the m3u
 type Chunk struct {
+	// Index is the chunk's position in the (pruned) list being downloaded
+	Index  int
 	Name   string
 	Length float64
 	URL    *url.URL
 	Path   string
+	// Range is set if the chunk is a byte range of its URL
+	Range *ByteRange
+	// Key is set if the chunk is AES-128 encrypted
+	Key *ChunkKey
+	// Map is the init section an fMP4 chunk needs, and Init is set for the
+	// chunk holding the init section itself
+	Map  *ChunkMap
+	Init bool
+	// SeqNo is the chunk's media sequence number
+	SeqNo uint64
+	// Start is the chunk's offset in seconds from the start of the playlist
+	Start float64
+	// Muted is set for chunks Twitch has replaced with a muted copy;
+	// UnmutedURL is where the original may still be found
+	Muted      bool
+	UnmutedURL *url.URL
 }
 
 // AuthGQLPayload represents the payload sent to the GQL endpoint to get the
@@ -89,3 +108,64 @@ type AuthGQLResponse struct {
 		} `json:"videoPlaybackAccessToken"`
 	} `json:"data"`
 }
+
+// VideoGQLPayload represents the payload sent to the GQL endpoint to get a
+// VOD's metadata
+type VideoGQLPayload struct {
+	OperationName string `json:"operationName"`
+	Query         string `json:"query"`
+	Variables     struct {
+		ID string `json:"id"`
+	} `json:"variables"`
+}
+
+func generateVideoPayload(vodID string) ([]byte, error) {
+	vp := VideoGQLPayload{
+		OperationName: "VideoMetadata",
+		Query:         "query VideoMetadata($id: ID!) {  video(id: $id) {    id    title    createdAt    lengthSeconds    viewCount    broadcastType    game {      name      displayName    }    owner {      login      displayName    }  }}",
+	}
+	vp.Variables.ID = vodID
+	return json.Marshal(vp)
+}
+
+// VideoGQLResponse represents the response from the GQL endpoint containing a
+// VOD's metadata; Video is nil if there is no such VOD
+type VideoGQLResponse struct {
+	Data struct {
+		Video *struct {
+			ID            string    `json:"id"`
+			Title         string    `json:"title"`
+			CreatedAt     time.Time `json:"createdAt"`
+			LengthSeconds int       `json:"lengthSeconds"`
+			ViewCount     int       `json:"viewCount"`
+			BroadcastType string    `json:"broadcastType"`
+			Game          *struct {
+				Name        string `json:"name"`
+				DisplayName string `json:"displayName"`
+			} `json:"game"`
+			Owner *struct {
+				Login       string `json:"login"`
+				DisplayName string `json:"displayName"`
+			} `json:"owner"`
+		} `json:"video"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// VODInfo is the metadata of a VOD
+type VODInfo struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	// Channel is the channel's display name, and ChannelLogin its name in URLs
+	Channel      string    `json:"channel"`
+	ChannelLogin string    `json:"channelLogin"`
+	CreatedAt    time.Time `json:"createdAt"`
+	// Length is the VOD's duration in seconds
+	Length    int    `json:"length"`
+	Game      string `json:"game"`
+	ViewCount int    `json:"viewCount"`
+	// Type is the kind of VOD ("archive", "highlight", or "upload")
+	Type string `json:"type"`
+}
@@ -4,17 +4,26 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/grafov/m3u8"
@@ -22,6 +31,16 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// gqlURL is Twitch's GraphQL endpoint
+const gqlURL = "https://gql.twitch.tv/gql"
+
+// OutputStdout is the Output that writes the download to stdout
+const OutputStdout = "-"
+
+// stdout is the real standard output; with "-o -" os.Stdout is pointed at
+// stderr so that status messages stay out of the stream
+var stdout = os.Stdout
+
 // vars intended to be populated via ldflags during build
 var (
 	// ClientID is provided by the Twitch API when registering an application
@@ -32,11 +51,19 @@ var (
 	date    = "n/a"
 
 	DefaultConfig = Config{
-		ClientID:  ClientID,
-		Workers:   4,
-		StartTime: "0 0 0",
-		EndTime:   "end",
-		Quality:   "best",
+		ClientID:         ClientID,
+		Workers:          4,
+		MinWorkers:       2,
+		MaxWorkers:       16,
+		StartTime:        "0 0 0",
+		EndTime:          "end",
+		Quality:          "best",
+		MaxAttempts:      5,
+		MutedPolicy:      MutedKeep,
+		Container:        ContainerAuto,
+		FilenameTemplate: DefaultFilenameTemplate,
+		ConnectTimeout:   "10s",
+		ReadTimeout:      "30s",
 	}
 	DefaultConfigFolder = os.ExpandEnv("${HOME}/.config/tvd/")
 	DefaultConfigFile   = "config.toml"
@@ -45,28 +72,66 @@ var (
 
 // command-line args/flags
 var (
-	clientID   = kingpin.Flag("client", "Twitch app Client ID").Short('C').String()
-	workers    = kingpin.Flag("workers", "Max number of concurrent downloads (default: 4)").Short('w').Int()
-	configFile = kingpin.Flag("config", "Path to config file (default: $HOME/.config/tvd/config.toml)").Short('c').String()
-	logFile    = kingpin.Flag("logfile", "Path to logfile").Short('L').String()
-
-	vodID = kingpin.Arg("vod", "ID of the VOD to download").Default("0").Int()
+	clientID        = kingpin.Flag("client", "Twitch app Client ID").Short('C').String()
+	workers         = kingpin.Flag("workers", "Max number of concurrent downloads, or 'auto' to adapt to throughput (default: 4)").Short('w').String()
+	configFile      = kingpin.Flag("config", "Path to config file (default: $HOME/.config/tvd/config.toml)").Short('c').String()
+	logFile         = kingpin.Flag("logfile", "Path to logfile").Short('L').String()
+	attempts        = kingpin.Flag("attempts", "Max number of attempts per chunk before giving up (default: 5)").Int()
+	limitRate       = kingpin.Flag("limit-rate", "Max total download rate across all workers (e.g. '5M' or '500K')").String()
+	ignoreDiskSpace = kingpin.Flag("ignore-disk-space", "Warn instead of failing when there may not be enough free disk space").Bool()
+	tempDir         = kingpin.Flag("temp-dir", "Folder to keep work files in (default: system temp dir)").String()
+	keepChunks      = kingpin.Flag("keep-chunks", "Keep downloaded chunks and an index.m3u8 of them in the work dir").Bool()
+	keepGoing       = kingpin.Flag("keep-going", "Leave out chunks that fail to download and report the gaps instead of failing").Bool()
+	container       = kingpin.Flag("container", "Output container: 'auto' (whatever the stream is), 'ts', 'mp4', or 'mkv'; other than 'auto' may need ffmpeg (default: auto)").String()
+	trim            = kingpin.Flag("trim", "Trim the output to the exact time range with ffmpeg").Bool()
+	reencodeHead    = kingpin.Flag("reencode-head", "When trimming, re-encode up to the first keyframe so the output starts exactly at the start time").Bool()
+	ffmpegPath      = kingpin.Flag("ffmpeg", "Path to the ffmpeg binary (default: ffmpeg from PATH)").String()
+	mutedPolicy     = kingpin.Flag("muted", "What to do with muted chunks: 'keep', 'unmute' (try the original first), or 'skip' (default: keep)").String()
+	connectTimeout  = kingpin.Flag("connect-timeout", "Timeout for establishing connections (e.g. '10s')").String()
+	readTimeout     = kingpin.Flag("read-timeout", "Timeout for a stalled response (e.g. '30s')").String()
+	timeout         = kingpin.Flag("timeout", "Timeout for a whole request, including reading the response (default: none)").String()
+	userAgent       = kingpin.Flag("user-agent", "User-Agent header for all requests").String()
+	headers         = kingpin.Flag("header", "Extra header for all requests, may be repeated (e.g. 'X-Foo: bar')").Short('H').Strings()
+	maxConnsPerHost = kingpin.Flag("max-conns-per-host", "Max number of connections per host (default: unlimited)").Int()
+	proxy           = kingpin.Flag("proxy", "Proxy URL (http, https, or socks5); defaults to HTTPS_PROXY/HTTP_PROXY from the environment").String()
+
+	downloadCmd = kingpin.Command("download", "Download a Twitch VOD").Default()
+	vodID       = downloadCmd.Arg("vod", "ID of the VOD to download").Default("0").Int()
+
+	hlsCmd   = kingpin.Command("hls", "Download from any HLS master or media playlist")
+	hlsInput = hlsCmd.Arg("input", "URL or local path of the .m3u8 playlist").Required().String()
+
+	formatsCmd  = kingpin.Command("formats", "List the qualities available for a Twitch VOD")
+	formatsVod  = formatsCmd.Arg("vod", "ID of the VOD").Required().Int()
+	formatsJSON = formatsCmd.Flag("json", "Print the list as JSON").Bool()
+
+	infoCmd  = kingpin.Command("info", "Show a Twitch VOD's title, channel, and other details")
+	infoVod  = infoCmd.Arg("vod", "ID of the VOD").Required().Int()
+	infoJSON = infoCmd.Flag("json", "Print the details as JSON").Bool()
 
 	quality   = kingpin.Flag("quality", "Desired quality (e.g. '720p30' or 'best')").Short('Q').String()
 	startTime = kingpin.Flag("start", "Start time for saved file (e.g. '0 15 0' to start at 15 minute mark)").Short('s').String()
 	endTime   = kingpin.Flag("end", "End time for saved file (e.g. '0 30 0' to end at 30 minute mark)").Short('e').String()
 	length    = kingpin.Flag("length", "Length from start time, overrides end time (e.g. '0 15 0' for 15 minutes from start time)").Short('l').String()
+	padding   = kingpin.Flag("padding", "Extra time to include before the start and after the end (e.g. '30s')").String()
 
-	prefix = kingpin.Flag("prefix", "Prefix for the output filename").Short('p').String()
-	folder = kingpin.Flag("folder", "Target folder for saved file (default: current dir)").Short('f').String()
-	// outFile = kingpin.Flag("output", "NOT YET IMPLEMENTED").Short('o').String()
+	prefix           = kingpin.Flag("prefix", "Prefix for the output filename").Short('p').String()
+	filenameTemplate = kingpin.Flag("filename-template", "Template for the output filename (e.g. '{{.Channel}}/{{.Date}} {{.Title}}.{{.Ext}}')").String()
+	folder           = kingpin.Flag("folder", "Target folder for saved file (default: current dir)").Short('f').String()
+	outFile          = kingpin.Flag("output", "Exact path for the output file, or '-' for stdout (overrides folder, prefix, and filename template)").Short('o').String()
 )
 
 func main() {
 	// parse command-line input
 	kingpin.CommandLine.HelpFlag.Short('h')
 	kingpin.Version(fmt.Sprintf("%s (commit %s; built %s)", version, commit, date))
-	kingpin.Parse()
+	cmd := kingpin.MustParse(kingpin.CommandLine.Parse(joinStdoutArgs(os.Args[1:])))
+
+	// everything printed from here on is status, which mustn't end up in the
+	// stream
+	if *outFile == OutputStdout {
+		os.Stdout = os.Stderr
+	}
 
 	// log to file if one is specified, otherwise write to nowhere
 	if *logFile != "" {
@@ -77,7 +142,7 @@ func main() {
 		defer func() {
 			err = logfile.Close()
 			if err != nil {
-				log.Fatalln("failed to close log file")
+				fmt.Println("failed to close log file")
 			}
 		}()
 		log.SetOutput(logfile)
@@ -136,14 +201,72 @@ func main() {
 	}
 	log.Printf("final config: %+v\n", config.Privatize())
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleInterrupts(cancel)
+
 	// go get it!
-	err = DownloadVOD(config)
+	switch cmd {
+	case hlsCmd.FullCommand():
+		err = DownloadHLS(ctx, config)
+	case formatsCmd.FullCommand():
+		err = ListFormats(ctx, config, *formatsJSON)
+	case infoCmd.FullCommand():
+		err = ShowVODInfo(ctx, config, *infoJSON)
+	default:
+		err = DownloadVOD(ctx, config)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Download interrupted")
+		log.Println(err)
+		os.Exit(130)
+	}
 	if err != nil {
 		fmt.Println(err)
 		log.Fatalln(err)
 	}
 }
 
+// joinStdoutArgs turns "-o -" and "--output -" into "-o-" and "--output=-",
+// since kingpin takes a lone "-" for a flag rather than a flag's value
+func joinStdoutArgs(args []string) []string {
+	res := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(res, args[i:]...)
+		}
+		if (arg == "-o" || arg == "--output") && i+1 < len(args) && args[i+1] == OutputStdout {
+			if arg == "-o" {
+				arg += OutputStdout
+			} else {
+				arg += "=" + OutputStdout
+			}
+			i++
+		}
+		res = append(res, arg)
+	}
+	return res
+}
+
+// handleInterrupts cancels the pipeline on the first SIGINT so in-flight
+// chunks can finish and resume state is saved, and quits immediately on the
+// second
+func handleInterrupts(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt)
+
+	<-sigs
+	fmt.Println("\nInterrupted, finishing in-flight chunks (press Ctrl-C again to force quit)")
+	log.Println("received interrupt, shutting down")
+	cancel()
+
+	<-sigs
+	fmt.Println("\nForce quitting")
+	log.Println("received second interrupt, force quitting")
+	os.Exit(130)
+}
+
 func createDefaultConfigFile() error {
 	err := os.MkdirAll(DefaultConfigFolder, os.ModePerm)
 	if err != nil {
@@ -163,101 +286,420 @@ func createDefaultConfigFile() error {
 }
 
 // DownloadVOD downloads a VOD based on the various info passed in the config
-func DownloadVOD(cfg Config) error {
+func DownloadVOD(ctx context.Context, cfg Config) error {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// the VOD's title and such are only needed to name the output
+	var info *VODInfo
+	if needsVODInfo(cfg.FilenameTemplate) {
+		fmt.Println("Fetching VOD info")
+		vi, err := getVODInfo(ctx, client, cfg.VodID, cfg.ClientID)
+		if err != nil {
+			return err
+		}
+		info = &vi
+	}
+
 	fmt.Println("Fetching access token")
-	ar, err := getAccessData(cfg.VodID, cfg.ClientID)
+	ar, err := getAccessData(ctx, client, cfg.VodID, cfg.ClientID)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("Fetching VOD stream options")
-	ql, err := getStreamOptions(cfg.VodID, ar)
+	variants, err := getStreamOptions(ctx, client, cfg.VodID, ar)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("Picking selected quality")
-	streamURL, ok := ql[cfg.Quality]
-	if !ok {
-		i := 0
-		options := make([]string, len(ql))
-		for k := range ql {
-			options[i] = k
-			i++
-		}
-		return fmt.Errorf("error: quality %s not available in list %+v", cfg.Quality, options)
+	variant, err := pickVariant(variants, cfg.Quality)
+	if err != nil {
+		return err
 	}
+
+	return downloadStream(ctx, cfg, client, strconv.Itoa(cfg.VodID), info, variant)
+}
+
+// streamOutput is where a download is written and how it gets there
+type streamOutput struct {
+	// native is the container the chunks make up when they're joined, and
+	// container the one the output is written as
+	native    string
+	container string
+	// extractAudio is set when only the audio of each chunk is kept
+	extractAudio bool
+	toStdout     bool
+	// ff is set when the download is remuxed or trimmed with ffmpeg
+	ff *ffmpeg
+
+	outFile string
+	// downloadFile is where the chunks are joined: outFile, or a file in the
+	// work dir that is remuxed into it
+	downloadFile string
+	workDir      string
+	manifest     *Manifest
+}
+
+// downloadStream downloads the chunks of a variant's media playlist within the
+// configured time range to an output file named after id and, if it was
+// fetched, the VOD's info
+func downloadStream(ctx context.Context, cfg Config, client *http.Client, id string, info *VODInfo, variant *Variant) error {
 	fmt.Println("Fetching chunk list")
-	chunks, chunkDur, err := getChunks(streamURL)
+	chunks, err := getChunks(ctx, client, variant.URI)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Pruning chunk list")
-	chunks, clipDur, err := pruneChunks(chunks, cfg.StartSec, cfg.EndSec, chunkDur)
+	padding, err := parseOptionalDuration(cfg.Padding)
+	if err != nil {
+		return fmt.Errorf("error: Padding is invalid: %w", err)
+	}
+
+	out, err := chooseOutput(cfg, chunks, variant)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Downloading chunks")
-	chunks, tempDir, err := downloadChunks(chunks, cfg.VodID, cfg.Workers)
+	dl, err := newDownloader(cfg, client, out.extractAudio)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		fmt.Println("Cleaning up temp files")
-		err = os.RemoveAll(tempDir)
-		if err != nil {
-			fmt.Printf("Failed to remove tempdir <%s>\n", tempDir)
-			log.Fatalln(err)
+
+	fmt.Println("Pruning chunk list")
+	chunks, clipStart, clipDur, err := pruneChunks(chunks, cfg.StartSec, cfg.EndSec, padding.Seconds())
+	if err != nil {
+		return err
+	}
+
+	muted := mutedRanges(chunks)
+	if len(muted) > 0 {
+		reportMutedRanges(muted, cfg.MutedPolicy)
+		if cfg.MutedPolicy == MutedSkip {
+			chunks = skipMutedChunks(chunks)
 		}
-	}()
+	}
 
-	fmt.Println("Building output filepath")
-	outFile, err := buildOutFilePath(cfg.VodID, cfg.StartSec, clipDur, cfg.FilePrefix, cfg.OutputFolder)
+	// fMP4 chunks need their init segment written first
+	chunks = withInitSegments(chunks)
+
+	if cfg.Trim {
+		// name the output after what will be left once it's trimmed
+		clipEnd := clipStart + clipDur
+		clipStart = math.Max(clipStart, float64(cfg.StartSec)-padding.Seconds())
+		if cfg.EndSec != -1 {
+			clipEnd = math.Min(clipEnd, float64(cfg.EndSec)+padding.Seconds())
+		}
+		clipDur = clipEnd - clipStart
+	}
+
+	err = placeOutput(cfg, out, id, info, variant, chunks, clipStart, clipDur)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Combining chunks to %s\n", outFile)
-	err = combineChunks(chunks, outFile)
+	asm, err := openAssembler(cfg, out, variant, chunks, clipDur)
 	if err != nil {
 		return err
 	}
+	dl.asm = asm
 
-	return nil
+	if out.toStdout {
+		fmt.Println("Downloading chunks to stdout")
+	} else {
+		fmt.Printf("Downloading chunks to %s\n", out.downloadFile)
+	}
+	err = downloadChunks(ctx, chunks, dl, cfg.Workers)
+	if err != nil {
+		closeErr := asm.Close()
+		if closeErr != nil {
+			log.Println(closeErr)
+		}
+		if out.toStdout {
+			// a stream to stdout can't be resumed, so its work dir is of no
+			// use unless the chunks were meant to be kept
+			fmt.Println("Download incomplete")
+			if !cfg.KeepChunks {
+				removeWorkDir(out.workDir)
+			}
+		} else {
+			fmt.Printf("Download incomplete, re-run the same command to resume (work dir: %s)\n", out.workDir)
+		}
+		return err
+	}
+
+	return finishOutput(ctx, cfg, out, asm, dl, chunks, muted, padding.Seconds())
 }
 
-func getAccessData(vodID int, clientID string) (AuthGQLResponse, error) {
-	log.Printf("[getAuthToken] vodID=%d\n", vodID)
-	var ar AuthGQLResponse
+// chooseOutput works out the container of the output and whether ffmpeg is
+// needed to write it. Chunks are joined as they are, so writing another
+// container (or trimming) is left to ffmpeg.
+func chooseOutput(cfg Config, chunks []Chunk, variant *Variant) (*streamOutput, error) {
+	audio := isAudioQuality(cfg.Quality) || variant.AudioOnly
+	native := nativeContainer(chunks, audio)
+	out := &streamOutput{
+		native:       native,
+		extractAudio: audio && native == ContainerAAC,
+		toStdout:     cfg.Output == OutputStdout,
+	}
 
-	ap, err := generateAuthPayload(strconv.Itoa(vodID))
+	var err error
+	out.container, err = chooseContainer(cfg, native, audio)
 	if err != nil {
-		return ar, err
+		return nil, err
+	}
+	log.Printf("stream container: %s, output container: %s", out.native, out.container)
+
+	// look for ffmpeg now rather than finding it missing after the download
+	if cfg.Trim || out.container != out.native {
+		out.ff, err = newFFmpeg(cfg.FFmpegPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// placeOutput names the output file, unless it was given, and sets up the
+// work dir the download is resumed from
+func placeOutput(cfg Config, out *streamOutput, id string, info *VODInfo, variant *Variant, chunks []Chunk, clipStart, clipDur float64) error {
+	out.outFile = cfg.Output
+	if out.outFile == "" {
+		fmt.Println("Building output filepath")
+		nameData := newFilenameData(id, info, variant, clipStart, clipDur, out.container)
+		var err error
+		out.outFile, err = buildOutFilePath(cfg.FilenameTemplate, nameData, cfg.FilePrefix, cfg.OutputFolder)
+		if err != nil {
+			return err
+		}
+	}
+	if !out.toStdout {
+		// the template can put the output in subfolders
+		err := os.MkdirAll(filepath.Dir(out.outFile), os.ModePerm)
+		if err != nil {
+			return err
+		}
 	}
 
-	url := "https://gql.twitch.tv/gql"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(ap))
+	// a stream can't be resumed, so it gets a work dir of its own rather than
+	// clobbering the resume state of a download of the same range to a file
+	workID := id
+	if out.toStdout {
+		workID += "_stdout"
+	}
+	rendition := variant.Key()
+	if out.extractAudio {
+		rendition += "_audio"
+	}
+	var err error
+	out.workDir, out.manifest, err = prepareWorkDir(cfg.TempFolder, workID, rendition, cfg.StartSec, cfg.EndSec)
 	if err != nil {
-		return ar, err
+		return err
 	}
-	req.Header.Set("Client-ID", clientID)
-	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
 
-	rsp, err := http.DefaultClient.Do(req)
+	// the chunks are downloaded to a file of their own container, which is
+	// then remuxed into outFile if that's a different one. That file is kept
+	// in the work dir so that it can't clobber one next to the output.
+	out.downloadFile = out.outFile
+	if out.container != out.native {
+		base := filepath.Base(out.outFile)
+		out.downloadFile = filepath.Join(out.workDir, strings.TrimSuffix(base, filepath.Ext(base))+"."+out.native)
+	}
+	if cfg.KeepChunks {
+		for i := range chunks {
+			name := chunkFileName(chunks[i])
+			if out.extractAudio {
+				name = strings.TrimSuffix(name, filepath.Ext(name)) + "." + ContainerAAC
+			}
+			chunks[i].Path = filepath.Join(out.workDir, name)
+		}
+	}
+
+	return nil
+}
+
+// openAssembler checks there's room for the download and opens (or resumes)
+// the output for the chunks to be written to
+func openAssembler(cfg Config, out *streamOutput, variant *Variant, chunks []Chunk, clipDur float64) (*assembler, error) {
+	// the check runs before the partial output is opened, so that running out
+	// of room doesn't leave an empty one behind
+	if len(chunks) > 0 && variant.Bandwidth > 0 {
+		// only the chunks left to download still need room
+		next := 0
+		if !out.toStdout && out.manifest.Output != "" {
+			if fi, err := os.Stat(out.manifest.Output); err == nil {
+				next, _ = out.manifest.ResumePoint(chunks, fi.Size())
+			}
+		}
+		remaining := 0.0
+		for _, c := range chunks[next:] {
+			remaining += c.Length
+		}
+		plan := downloadPlan{
+			Bandwidth:   variant.Bandwidth,
+			Remaining:   remaining,
+			Clip:        clipDur,
+			Chunks:      len(chunks),
+			KeepChunks:  cfg.KeepChunks,
+			Remux:       out.downloadFile != out.outFile,
+			PostProcess: out.ff != nil,
+			WorkDir:     out.workDir,
+		}
+		if !out.toStdout {
+			plan.OutDir = filepath.Dir(out.outFile)
+		}
+		fmt.Printf("Estimated download size: %s\n", formatBytes(int64(estimateSize(plan.Bandwidth, plan.Remaining))))
+		err := checkDiskSpace(plan.requirements(), cfg.IgnoreDiskSpace)
+		if err != nil {
+			// a work dir with nothing to resume was only just created
+			if out.manifest.Len() == 0 {
+				removeWorkDir(out.workDir)
+			}
+			return nil, err
+		}
+	}
+
+	if out.toStdout {
+		return newStreamAssembler(chunks, out.manifest, stdout)
+	}
+	return newAssembler(chunks, out.manifest, out.downloadFile)
+}
+
+// newDownloader sets up the chunk downloader from the config; its assembler
+// is set once the output is opened
+func newDownloader(cfg Config, client *http.Client, audioOnly bool) (*downloader, error) {
+	dl := &downloader{
+		client:     client,
+		keys:       newKeyCache(client),
+		stats:      &poolStats{},
+		keepGoing:  cfg.KeepGoing,
+		unmute:     cfg.MutedPolicy == MutedUnmute,
+		audioOnly:  audioOnly,
+		minWorkers: cfg.MinWorkers,
+		maxWorkers: cfg.MaxWorkers,
+		retry: RetryPolicy{
+			MaxAttempts: cfg.MaxAttempts,
+			BaseDelay:   time.Second,
+			MaxDelay:    30 * time.Second,
+		},
+	}
+	if cfg.LimitRate != "" {
+		rate, err := parseRate(cfg.LimitRate)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("limiting download rate to %d bytes/sec", rate)
+		dl.limiter = NewRateLimiter(rate)
+	}
+	return dl, nil
+}
+
+// finishOutput moves a complete download into place, post-processes it with
+// ffmpeg if needed, reports what was left out or muted, and cleans up the
+// work dir
+func finishOutput(ctx context.Context, cfg Config, out *streamOutput, asm *assembler, dl *downloader, chunks []Chunk, muted []MutedRange, padding float64) error {
+	err := asm.Finish()
 	if err != nil {
-		return ar, err
+		return err
 	}
-	defer func() {
-		err = rsp.Body.Close()
+
+	if gaps := asm.Gaps(); len(gaps) > 0 {
+		err = writeGapReport(out.outFile, gaps)
 		if err != nil {
-			fmt.Printf("error closing URL body for <%s>: %s", url, err.Error())
-			log.Println(err)
+			return err
 		}
-	}()
+	}
+
+	if out.ff != nil {
+		err = trimToRange(ctx, cfg, out.ff, out.downloadFile, out.outFile, out.workDir, chunks, asm.Gaps(), padding)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(muted) > 0 {
+		if dl.unmute {
+			fmt.Printf("Recovered the original audio for %d muted chunk(s)\n", dl.unmuted)
+		}
+		if !out.toStdout {
+			err = writeMutedReport(out.outFile, muted, cfg.MutedPolicy)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.KeepChunks {
+		missing, err := writeChunkIndex(out.workDir, chunks, asm.Gaps())
+		if err != nil {
+			return err
+		}
+		if missing > 0 {
+			fmt.Printf("%d chunk(s) downloaded by an earlier run without keep-chunks are left out of %s\n", missing, ChunkIndexFile)
+		}
+		fmt.Printf("Chunks kept in %s\n", out.workDir)
+	} else {
+		removeWorkDir(out.workDir)
+	}
+
+	return nil
+}
+
+// trimToRange writes the downloaded file to outFile, trimmed to the exact
+// requested range (plus padding) if Trim is set, since the chunks only
+// roughly cover it, and remuxed if outFile is a different container
+func trimToRange(ctx context.Context, cfg Config, ff *ffmpeg, downloadFile, outFile, workDir string, chunks []Chunk, gaps []Gap, padding float64) error {
+	if !cfg.Trim {
+		fmt.Printf("Remuxing to %s\n", outFile)
+		return remuxOutput(ctx, ff, downloadFile, outFile)
+	}
 
-	rspData, err := ioutil.ReadAll(rsp.Body)
+	// the output doesn't have the chunks left out as gaps, so the cut points
+	// after one are earlier in it than in the playlist
+	chunks = withoutGaps(chunks, gaps)
+	start := outputOffset(chunks, float64(cfg.StartSec)-padding)
+	end := 0.0
+	if cfg.EndSec != -1 {
+		end = outputOffset(chunks, float64(cfg.EndSec)+padding)
+		total := 0.0
+		for _, c := range chunks {
+			total += c.Length
+		}
+		if end >= total-0.001 {
+			end = 0
+		}
+	}
+	if start < 0.001 && end == 0 {
+		log.Println("[trimToRange] output already matches the range, not trimming")
+		if downloadFile != outFile {
+			fmt.Printf("Remuxing to %s\n", outFile)
+			return remuxOutput(ctx, ff, downloadFile, outFile)
+		}
+		return nil
+	}
+
+	to := "the end"
+	if end > 0 {
+		to = fmt.Sprintf("%.3fs", end)
+	}
+	fmt.Printf("Trimming output from %.3fs to %s with ffmpeg\n", start, to)
+	return trimOutput(ctx, ff, downloadFile, outFile, workDir, start, end, cfg.TrimReencodeHead)
+}
+
+func getAccessData(ctx context.Context, client *http.Client, vodID int, clientID string) (AuthGQLResponse, error) {
+	log.Printf("[getAuthToken] vodID=%d\n", vodID)
+	var ar AuthGQLResponse
+
+	ap, err := generateAuthPayload(strconv.Itoa(vodID))
+	if err != nil {
+		return ar, err
+	}
+
+	rspData, err := postGQL(ctx, client, clientID, ap)
 	if err != nil {
 		return ar, err
 	}
@@ -276,195 +718,639 @@ func getAccessData(vodID int, clientID string) (AuthGQLResponse, error) {
 	return ar, nil
 }
 
-func getStreamOptions(vodID int, ar AuthGQLResponse) (map[string]string, error) {
-	log.Printf("[getStreamOptions] vodID=%d, ar=%+v\n", vodID, ar)
-	var ql = make(map[string]string)
+// getVODInfo fetches a VOD's title, channel, and other metadata
+func getVODInfo(ctx context.Context, client *http.Client, vodID int, clientID string) (VODInfo, error) {
+	log.Printf("[getVODInfo] vodID=%d\n", vodID)
+	info := VODInfo{ID: vodID}
 
-	url := fmt.Sprintf(
-		"https://usher.ttvnw.net/vod/%d.m3u8?allow_source=true&sig=%s&token=%s",
-		vodID,
-		ar.Data.VideoPlaybackAccessToken.Signature,
-		ar.Data.VideoPlaybackAccessToken.Value,
-	)
-	rsp, err := http.Get(url)
+	vp, err := generateVideoPayload(strconv.Itoa(vodID))
+	if err != nil {
+		return info, err
+	}
+
+	rspData, err := postGQL(ctx, client, clientID, vp)
+	if err != nil {
+		return info, err
+	}
+
+	var vr VideoGQLResponse
+	err = json.Unmarshal(rspData, &vr)
+	if err != nil {
+		return info, err
+	}
+	if len(vr.Errors) > 0 {
+		log.Printf("response: %s\n", rspData)
+		return info, fmt.Errorf("error: failed to fetch info for VOD %d: %s", vodID, vr.Errors[0].Message)
+	}
+	v := vr.Data.Video
+	if v == nil {
+		return info, fmt.Errorf("error: VOD %d not found", vodID)
+	}
+
+	info.Title = v.Title
+	info.CreatedAt = v.CreatedAt
+	info.Length = v.LengthSeconds
+	info.ViewCount = v.ViewCount
+	info.Type = strings.ToLower(v.BroadcastType)
+	if v.Owner != nil {
+		info.Channel = v.Owner.DisplayName
+		info.ChannelLogin = v.Owner.Login
+		if info.Channel == "" {
+			info.Channel = v.Owner.Login
+		}
+	}
+	if v.Game != nil {
+		info.Game = v.Game.DisplayName
+		if info.Game == "" {
+			info.Game = v.Game.Name
+		}
+	}
+
+	log.Printf("VOD info: %+v\n", info)
+
+	return info, nil
+}
+
+// postGQL sends a payload to the GQL endpoint and returns the response body
+func postGQL(ctx context.Context, client *http.Client, clientID string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", gqlURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Client-ID", clientID)
+	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+
+	rsp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		err = rsp.Body.Close()
 		if err != nil {
-			fmt.Printf("error closing URL body for <%s>: %s", url, err.Error())
+			fmt.Printf("error closing URL body for <%s>: %s", gqlURL, err.Error())
 			log.Println(err)
 		}
 	}()
 
-	p, listType, err := m3u8.DecodeFrom(rsp.Body, true)
+	return ioutil.ReadAll(rsp.Body)
+}
+
+func getStreamOptions(ctx context.Context, client *http.Client, vodID int, ar AuthGQLResponse) ([]*Variant, error) {
+	log.Printf("[getStreamOptions] vodID=%d, ar=%+v\n", vodID, ar)
+
+	url := fmt.Sprintf(
+		"https://usher.ttvnw.net/vod/%d.m3u8?allow_source=true&allow_audio_only=true&sig=%s&token=%s",
+		vodID,
+		ar.Data.VideoPlaybackAccessToken.Signature,
+		ar.Data.VideoPlaybackAccessToken.Value,
+	)
+	p, listType, err := fetchPlaylist(ctx, client, url)
 	if err != nil {
-		log.Printf("failed to decode m3u8: %s\n", err.Error())
 		return nil, err
 	}
 
-	switch listType {
-	case m3u8.MASTER:
-		masterPl := p.(*m3u8.MasterPlaylist)
-		var bestBandwidth uint32
-		for _, v := range masterPl.Variants {
-			ql[v.Resolution] = v.URI
-			if v.Bandwidth > bestBandwidth {
-				bestBandwidth = v.Bandwidth
-				ql["best"] = v.URI
-			}
-
-		}
-	default:
+	if listType != m3u8.MASTER {
 		log.Println("m3u8 playlist was not the expected 'master' format")
 		return nil, fmt.Errorf("m3u8 playlist was not the expected 'master' format")
 	}
+	variants := newVariants(p.(*m3u8.MasterPlaylist), url)
 
-	log.Printf("qualities options found: %+v\n", ql)
+	log.Printf("qualities options found: %+v\n", variants)
 
-	return ql, nil
+	return variants, nil
 }
 
-func getChunks(streamURL string) ([]Chunk, int, error) {
-	var chunks []Chunk
-	var chunkDur int
+// pickVariant picks the variant for the requested quality, which may be a
+// comma-separated list of preferences where the first one available wins
+func pickVariant(variants []*Variant, quality string) (*Variant, error) {
+	if isAudioQuality(quality) {
+		return pickAudioVariant(variants)
+	}
+
+	for _, pref := range splitQuality(quality) {
+		variant, reason := matchQuality(variants, pref)
+		if variant == nil {
+			log.Printf("[pickVariant] quality '%s' not available", pref)
+			continue
+		}
+		log.Printf("[pickVariant] picked %s <%s> for quality '%s': %s", variant, variant.URI, pref, reason)
+		return variant, nil
+	}
+
+	return nil, fmt.Errorf("error: quality %s not available in list %+v", quality, variantNames(variants))
+}
 
-	rsp, err := http.Get(streamURL)
+// fetchPlaylist downloads and decodes an m3u8 playlist of either type
+func fetchPlaylist(ctx context.Context, client *http.Client, playlistURL string) (m3u8.Playlist, m3u8.ListType, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", playlistURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	rsp, err := client.Do(req)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer func() {
 		err = rsp.Body.Close()
 		if err != nil {
-			fmt.Printf("error closing URL body for <%s>: %s", streamURL, err.Error())
+			fmt.Printf("error closing URL body for <%s>: %s", playlistURL, err.Error())
 			log.Println(err)
 		}
 	}()
 
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return nil, 0, &HTTPStatusError{URL: playlistURL, StatusCode: rsp.StatusCode}
+	}
+
 	p, listType, err := m3u8.DecodeFrom(rsp.Body, true)
 	if err != nil {
 		log.Printf("failed to decode m3u8: %s\n", err.Error())
 		return nil, 0, err
 	}
 
+	return p, listType, nil
+}
+
+func getChunks(ctx context.Context, client *http.Client, streamURL string) ([]Chunk, error) {
+	var chunks []Chunk
+
+	p, listType, err := fetchPlaylist(ctx, client, streamURL)
+	if err != nil {
+		return nil, err
+	}
+
 	switch listType {
 	case m3u8.MEDIA:
 		mediaPl := p.(*m3u8.MediaPlaylist)
 
-		chunkDur = int(mediaPl.TargetDuration)
-		log.Printf("target chunk duration: %d", chunkDur)
+		log.Printf("target chunk duration: %v", mediaPl.TargetDuration)
 
 		// "safe" to ignore - previously fetched
 		baseURL, _ := url.Parse(streamURL)
+		var key *ChunkKey
+		var chunkMap *ChunkMap
+		start := 0.0
 		for i := 0; i < int(mediaPl.Count()); i++ {
 			// "safe" to ignore - per format spec
 			s := mediaPl.Segments[i]
-			chunkPath, _ := url.Parse(s.URI)
-			chunkURL := baseURL.ResolveReference(chunkPath)
-			chunks = append(chunks, Chunk{Name: s.URI, Length: s.Duration, URL: chunkURL})
+			chunkURL, err := resolveURI(baseURL, s.URI)
+			if err != nil {
+				return nil, err
+			}
+
+			// an EXT-X-KEY applies to every segment after it until the next one
+			if s.Key != nil {
+				key, err = parseChunkKey(s.Key, baseURL)
+				if err != nil {
+					return nil, err
+				}
+			}
+			// and so does an EXT-X-MAP
+			if s.Map != nil {
+				chunkMap, err = parseChunkMap(s.Map, baseURL)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			c := Chunk{
+				Name:   s.URI,
+				Length: s.Duration,
+				URL:    chunkURL,
+				Key:    key,
+				Map:    chunkMap,
+				SeqNo:  mediaPl.SeqNo + uint64(i),
+				Start:  start,
+			}
+			if s.Limit > 0 {
+				c.Range = &ByteRange{Offset: s.Offset, Length: s.Limit}
+				// a byte range without an offset continues from the end of
+				// the previous one in the same file
+				if n := len(chunks); s.Offset == 0 && n > 0 {
+					prev := chunks[n-1]
+					if prev.Range != nil && prev.URL.String() == chunkURL.String() {
+						c.Range.Offset = prev.Range.Offset + prev.Range.Length
+					}
+				}
+			}
+			if isMutedURI(chunkURL) {
+				c.Muted = true
+				c.UnmutedURL = unmutedURL(chunkURL)
+			}
+			chunks = append(chunks, c)
+			start += s.Duration
 		}
 	default:
 		log.Println("m3u8 playlist was not the expected 'media' format")
-		return nil, 0, fmt.Errorf("m3u8 playlist was not the expected 'media' format")
+		return nil, fmt.Errorf("m3u8 playlist was not the expected 'media' format")
 	}
 
-	return chunks, chunkDur, nil
+	return chunks, nil
 }
 
-func pruneChunks(chunks []Chunk, startSec, endSec int, duration int) ([]Chunk, int, error) {
-	startAt := startSec / duration
-	// assume "end", work to determine actual end chunk if different
-	endAt := len(chunks)
+// pruneChunks keeps the chunks that overlap the range from startSec to endSec
+// (-1 for the end of the VOD), widened by padding seconds on either side.
+// Chunks are placed by their actual lengths rather than the playlist's target
+// duration, since segments can be shorter or irregular. It returns the kept
+// chunks along with the offset in seconds of the first one and their total
+// duration.
+func pruneChunks(chunks []Chunk, startSec, endSec int, padding float64) ([]Chunk, float64, float64, error) {
+	from := float64(startSec) - padding
+	to := math.Inf(1)
 	if endSec != -1 {
-		endAt = endSec / duration
+		to = float64(endSec) + padding
 	}
-	if endAt > len(chunks) {
-		endAt = len(chunks)
-	}
-
-	log.Println("Chunk management:")
-	log.Printf("Start at chunk:          %4d\n", startAt)
-	log.Printf("End at chunk:            %4d\n", endAt)
 
-	res := chunks[startAt:endAt]
+	// tolerates rounding in the sum of segment lengths, so that a chunk
+	// ending right at the start of the range isn't kept
+	const epsilon = 0.001
 
-	log.Printf("Number of pruned chunks: %4d\n", len(res))
+	var res []Chunk
+	for _, c := range chunks {
+		if c.Start+c.Length > from+epsilon && c.Start < to-epsilon {
+			c.Index = len(res)
+			res = append(res, c)
+		}
+	}
+	if len(res) == 0 {
+		return nil, 0, 0, fmt.Errorf("error: no chunks found from %s; the VOD is only %s long", secondsToTimeMask(startSec), formatSeconds(playlistDuration(chunks)))
+	}
 
+	startAt := res[0].Start
 	actualDuration := 0.0
 	for _, c := range res {
 		actualDuration += c.Length
 	}
 
-	return res, int(actualDuration), nil
+	log.Println("Chunk management:")
+	log.Printf("Requested range:         %.3fs to %.3fs (padding %.3fs)\n", from, to, padding)
+	log.Printf("Start at chunk:          %4d (%.3fs)\n", res[0].SeqNo, startAt)
+	log.Printf("End at chunk:            %4d (%.3fs)\n", res[len(res)-1].SeqNo, startAt+actualDuration)
+	log.Printf("Number of pruned chunks: %4d\n", len(res))
+
+	return res, startAt, actualDuration, nil
 }
 
-func downloadChunks(chunks []Chunk, vodID, workers int) ([]Chunk, string, error) {
-	tempDir, err := ioutil.TempDir("", fmt.Sprintf("tvd_%d", vodID))
-	if err != nil {
-		return nil, "", err
+// playlistDuration returns the total length in seconds of a list of chunks
+func playlistDuration(chunks []Chunk) float64 {
+	if len(chunks) == 0 {
+		return 0
 	}
+	last := chunks[len(chunks)-1]
+	return last.Start + last.Length
+}
 
-	jobs := make(chan Chunk, len(chunks))
-	results := make(chan string, len(chunks))
+// downloader holds the state shared by every download worker
+type downloader struct {
+	client  *http.Client
+	keys    *keyCache
+	asm     *assembler
+	retry   RetryPolicy
+	limiter *RateLimiter
+	stats   *poolStats
+	// keepGoing leaves a gap for chunks that fail instead of stopping
+	keepGoing bool
+	// unmute tries the original of each muted chunk before the muted copy;
+	// unmuted counts the chunks where that worked
+	unmute  bool
+	unmuted int
+	// audioOnly keeps only the AAC audio of MPEG-TS chunks
+	audioOnly bool
+
+	minWorkers int
+	maxWorkers int
+}
 
-	// Spin up workers
-	log.Printf("spinning up %d workers", workers)
-	for w := 1; w <= workers; w++ {
-		go downloadWorker(w, jobs, results)
+// chunkResult is sent from a worker for every chunk it processes
+type chunkResult struct {
+	Chunk    Chunk
+	Data     []byte
+	Hash     string
+	Attempts int
+	// Unmuted is set if the original of a muted chunk was downloaded
+	Unmuted bool
+	Err     error
+}
+
+// downloadChunks downloads every chunk the assembler still needs and hands
+// them to it as they arrive. Chunks are handed out in order and no more than
+// reorderBuffer chunks beyond the ones being downloaded are handed out ahead
+// of the assembler, which bounds how many chunks are held in memory.
+//
+// Cancelling ctx stops workers from starting new chunks; chunks already in
+// flight are allowed to finish so that their progress is saved.
+//
+// With AutoWorkers, the pool starts at dl.minWorkers and is resized between
+// dl.minWorkers and dl.maxWorkers based on observed throughput and errors.
+func downloadChunks(ctx context.Context, chunks []Chunk, dl *downloader, workers WorkerCount) error {
+	// Skip chunks completed by a previous run
+	pending := chunks[dl.asm.Next():]
+	if done := len(chunks) - len(pending); done > 0 {
+		fmt.Printf("Resuming download: %d of %d chunks already complete\n", done, len(chunks))
+		log.Printf("resuming: skipping %d completed chunks", done)
+	}
+
+	// poolCtx is also cancelled on the first failed chunk
+	poolCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	if len(pending) == 0 {
+		// no result will arrive to stop the pool, and the scaler would
+		// otherwise hold it open until its next tick
+		stop()
+	}
+
+	maxWorkers := int(workers)
+	if workers == AutoWorkers {
+		maxWorkers = dl.maxWorkers
 	}
 
+	jobs := make(chan Chunk, len(pending))
+	results := make(chan chunkResult, len(pending))
+	// a slot is taken for each chunk handed out and given back once the
+	// assembler has written it
+	window := make(chan struct{}, maxWorkers+reorderBuffer)
+	var undispatched int64 = int64(len(pending))
+
 	// Fill job queue with chunks
-	log.Printf("filling job queue with %d chunks", len(chunks))
-	for i, c := range chunks {
-		c.Path = filepath.Join(tempDir, c.Name)
-		chunks[i] = c
-		jobs <- c
+	log.Printf("filling job queue with %d chunks", len(pending))
+	go func() {
+		defer close(jobs)
+		for _, c := range pending {
+			select {
+			case window <- struct{}{}:
+			case <-poolCtx.Done():
+				return
+			}
+			jobs <- c
+			atomic.AddInt64(&undispatched, -1)
+		}
+	}()
+
+	// Spin up workers
+	var wg sync.WaitGroup
+	var retire chan struct{}
+	nextID := 0
+	spawn := func() {
+		nextID++
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			dl.worker(poolCtx, id, jobs, retire, results)
+		}(nextID)
 	}
-	close(jobs)
+
+	var scaler *autoScaler
+	startWorkers := int(workers)
+	if workers == AutoWorkers {
+		retire = make(chan struct{}, dl.maxWorkers)
+		startWorkers = dl.minWorkers
+		scaler = &autoScaler{
+			min:     dl.minWorkers,
+			max:     dl.maxWorkers,
+			stats:   dl.stats,
+			spawn:   spawn,
+			retire:  retire,
+			pending: func() int { return len(jobs) + int(atomic.LoadInt64(&undispatched)) },
+			workers: startWorkers,
+			peak:    startWorkers,
+		}
+	}
+
+	log.Printf("spinning up %d workers", startWorkers)
+	for w := 1; w <= startWorkers; w++ {
+		spawn()
+	}
+	if scaler != nil {
+		log.Printf("auto workers: scaling between %d and %d workers", dl.minWorkers, dl.maxWorkers)
+		// counted in wg so that no worker can be spawned after wg.Wait returns
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scaler.run(poolCtx)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
 	// Wait for results to come in
 	log.Printf("waiting for results from workers")
 	bar := progressbar.Default(int64(len(chunks)))
-	for r := 0; r < len(chunks); r++ {
-		res := <-results
-		// below error-catching is untested... cross your fingers
-		if len(res) != 0 {
-			close(results)
-			return nil, "", fmt.Errorf("error: a worker returned an error: %s", res)
+	err := bar.Add(len(chunks) - len(pending))
+	if err != nil {
+		return fmt.Errorf("error: failed to increment progress bar: %w", err)
+	}
+	var failed ChunkErrors
+	var fatal bool
+	var writeErr error
+	retries, completed, received := 0, 0, 0
+	for res := range results {
+		received++
+		retries += res.Attempts - 1
+		if received == len(pending) {
+			// every chunk is accounted for, let the workers and scaler go
+			stop()
+		}
+		if res.Err != nil {
+			if errors.Is(res.Err, context.Canceled) {
+				continue
+			}
+			failed = append(failed, newChunkError(res))
+			// a missing init segment would make everything after it
+			// unplayable, so it isn't left as a gap
+			if !dl.keepGoing || res.Chunk.Init {
+				fatal = true
+				// let in-flight chunks finish, but don't start any more
+				stop()
+				continue
+			}
+			log.Printf("chunk %s failed, leaving a gap: %s", res.Chunk.Name, res.Err)
+		} else {
+			completed++
+			if res.Unmuted {
+				dl.unmuted++
+			}
 		}
 		err := bar.Add(1)
 		if err != nil {
-			return nil, "", fmt.Errorf("error: failed to increment progress bar: %w", err)
+			log.Printf("failed to increment progress bar: %s", err)
+		}
+
+		if writeErr != nil {
+			continue
+		}
+		appended, err := dl.asm.Add(res)
+		if err != nil {
+			writeErr = fmt.Errorf("error: failed to write chunk %s to output: %w", res.Chunk.Name, err)
+			stop()
+			continue
+		}
+		for i := 0; i < appended; i++ {
+			<-window
 		}
 	}
+	log.Printf("downloaded %d chunks with %d retries", completed, retries)
+	if scaler != nil {
+		log.Printf("auto workers: finished with %d workers (peak %d)", scaler.workers, scaler.peak)
+	}
+	failed.Sort()
+	for _, ce := range failed {
+		log.Println(ce)
+	}
+
+	if writeErr != nil {
+		return writeErr
+	}
+	if fatal {
+		fmt.Printf("\nSaved progress: %d of %d chunks complete\n", dl.asm.Next(), len(chunks))
+		return failed
+	}
+	if ctx.Err() != nil {
+		fmt.Printf("\nSaved progress: %d of %d chunks complete\n", dl.asm.Next(), len(chunks))
+		return ctx.Err()
+	}
+
 	err = bar.Finish()
 	if err != nil {
-		return nil, "", fmt.Errorf("error: failed to finalize progress bar: %w", err)
+		return fmt.Errorf("error: failed to finalize progress bar: %w", err)
+	}
+	if scaler != nil {
+		fmt.Printf("Downloaded %d chunks (%d retries, settled on %d workers)\n", completed, retries, scaler.workers)
+	} else {
+		fmt.Printf("Downloaded %d chunks (%d retries)\n", completed, retries)
 	}
 
-	return chunks, tempDir, nil
+	return nil
 }
 
-func downloadWorker(id int, chunks <-chan Chunk, results chan<- string) {
+// worker downloads chunks until the queue is empty, ctx is cancelled, or it
+// receives from retire (nil for a fixed-size pool)
+func (dl *downloader) worker(ctx context.Context, id int, chunks <-chan Chunk, retire <-chan struct{}, results chan<- chunkResult) {
 	log.Printf("worker %02d: spinning up", id)
-	for chunk := range chunks {
+	for {
+		select {
+		case <-retire:
+			log.Printf("worker %02d: retired", id)
+			return
+		default:
+		}
+
+		chunk, ok := <-chunks
+		if !ok {
+			return
+		}
+		if ctx.Err() != nil {
+			log.Printf("worker %02d: cancelled, shutting down", id)
+			return
+		}
 		log.Printf("worker %02d: received a chunk", id)
-		err := downloadChunk(chunk)
-		res := ""
-		if err != nil {
+		res := dl.fetchChunk(ctx, id, chunk)
+		if res.Err != nil {
 			log.Printf("worker %02d: chunk download failed", id)
-			res = err.Error()
+		} else {
+			log.Printf("worker %02d: downloaded a chunk", id)
 		}
-		log.Printf("worker %02d: downloaded a chunk", id)
 		results <- res
 	}
 }
 
-func downloadChunk(c Chunk) error {
-	resp, err := http.Get(c.URL.String())
+// fetchChunk downloads a chunk, retrying retryable failures with backoff.
+// Cancelling ctx abandons any remaining retries, but not an attempt that is
+// already in flight.
+func (dl *downloader) fetchChunk(ctx context.Context, id int, c Chunk) chunkResult {
+	if dl.unmute && c.Muted {
+		res := dl.fetchUnmuted(id, c)
+		if res.Err == nil {
+			return res
+		}
+	}
+	res := chunkResult{Chunk: c}
+	for res.Attempts < dl.retry.MaxAttempts {
+		res.Attempts++
+		// deliberately not tied to ctx so an interrupt lets the chunk finish
+		data, hash, err := dl.downloadChunk(context.Background(), c)
+		dl.stats.record(int64(len(data)), err)
+		if err == nil && c.Path != "" {
+			// keep-chunks mode
+			err = ioutil.WriteFile(c.Path, data, 0644)
+			if err != nil {
+				res.Err = fmt.Errorf("error: failed to save chunk %s: %w", c.Name, err)
+				return res
+			}
+		}
+		if err == nil {
+			res.Data, res.Hash, res.Err = data, hash, nil
+			return res
+		}
+		res.Err = err
+
+		if !isRetryable(err) {
+			log.Printf("worker %02d: chunk %s attempt %d/%d failed (fatal): %s", id, c.Name, res.Attempts, dl.retry.MaxAttempts, err)
+			return res
+		}
+		if res.Attempts >= dl.retry.MaxAttempts {
+			log.Printf("worker %02d: chunk %s attempt %d/%d failed, giving up: %s", id, c.Name, res.Attempts, dl.retry.MaxAttempts, err)
+			return res
+		}
+
+		delay := dl.retry.Delay(res.Attempts)
+		log.Printf("worker %02d: chunk %s attempt %d/%d failed (retryable), retrying in %s: %s", id, c.Name, res.Attempts, dl.retry.MaxAttempts, delay, err)
+		select {
+		case <-ctx.Done():
+			res.Err = ctx.Err()
+			return res
+		case <-time.After(delay):
+		}
+	}
+
+	return res
+}
+
+// fetchUnmuted makes a single attempt at the original of a muted chunk. The
+// original has usually been deleted, so failures aren't retried; the muted
+// copy is downloaded instead.
+func (dl *downloader) fetchUnmuted(id int, c Chunk) chunkResult {
+	unmuted := c
+	unmuted.URL = c.UnmutedURL
+	res := chunkResult{Chunk: c, Attempts: 1}
+	data, hash, err := dl.downloadChunk(context.Background(), unmuted)
+	// most muted chunks have no unmuted original, so a failed probe is
+	// expected and mustn't count against the pool's error rate
+	if err == nil {
+		dl.stats.record(int64(len(data)), nil)
+	}
+	if err == nil && c.Path != "" {
+		err = ioutil.WriteFile(c.Path, data, 0644)
+	}
 	if err != nil {
-		return err
+		log.Printf("worker %02d: unmuted chunk %s unavailable, using the muted one: %s", id, unmuted.URL, err)
+		res.Err = err
+		return res
+	}
+	log.Printf("worker %02d: got unmuted chunk %s", id, unmuted.URL)
+	res.Data, res.Hash, res.Unmuted = data, hash, true
+	return res
+}
+
+// downloadChunk fetches a chunk (or its byte range) into memory and returns
+// its contents and hash. Encrypted chunks are decrypted, and the result is
+// validated as MPEG-TS or fMP4, so a chunk is only returned once every check
+// has passed. In audio-only mode, just the chunk's audio is returned.
+//
+// If dl.limiter is not nil, reading the response body is throttled by it.
+func (dl *downloader) downloadChunk(ctx context.Context, c Chunk) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.URL.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.Range != nil {
+		req.Header.Set("Range", c.Range.Header())
+	}
+	resp, err := dl.client.Do(req)
+	if err != nil {
+		return nil, "", err
 	}
 	defer func() {
 		err = resp.Body.Close()
@@ -473,35 +1359,80 @@ func downloadChunk(c Chunk) error {
 		}
 	}()
 
-	chunkFile, err := os.Create(c.Path)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", &HTTPStatusError{URL: c.URL.String(), StatusCode: resp.StatusCode}
+	}
+
+	var body io.Reader = resp.Body
+	if dl.limiter != nil {
+		body = dl.limiter.Reader(ctx, body)
+	}
+
+	var buf bytes.Buffer
+	if resp.ContentLength > 0 {
+		buf.Grow(int(resp.ContentLength))
+	}
+	n, err := io.Copy(&buf, body)
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = fmt.Errorf("error: chunk %s short read, got %d of %d bytes: %w", c.Name, n, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	defer func() {
-		err = chunkFile.Close()
+	data := buf.Bytes()
+
+	if c.Range != nil && resp.StatusCode != http.StatusPartialContent {
+		// the server ignored the Range header and sent the whole file
+		if int64(len(data)) < c.Range.Offset+c.Range.Length {
+			return nil, "", &ValidationError{Chunk: c.Name, Reason: fmt.Sprintf("file of %d bytes does not contain byte range %s", len(data), c.Range.Header())}
+		}
+		data = data[c.Range.Offset : c.Range.Offset+c.Range.Length]
+	}
+
+	if c.Key != nil {
+		key, err := dl.keys.Get(ctx, c.Key.URI)
 		if err != nil {
-			fmt.Printf("error closing chunk file %s: %s\n", c.Name, err)
-			log.Fatalln(err)
+			return nil, "", fmt.Errorf("error: failed to fetch key for chunk %s: %w", c.Name, err)
 		}
-	}()
+		data, err = decryptChunk(c, key, data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
-	_, err = io.Copy(chunkFile, resp.Body)
+	if isFMP4(c) {
+		err = validateMP4(c, data)
+	} else {
+		v := &tsValidator{chunk: c.Name}
+		_, err = v.Write(data)
+		if err == nil {
+			err = v.Close()
+		}
+	}
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	return nil
-}
+	if dl.audioOnly {
+		data, err = extractAAC(c, data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
-func buildOutFilePath(vodID int, startAt int, dur int, prefix string, folder string) (string, error) {
-	startTime := secondsToTimeMask(startAt)
-	endAt := startAt + dur
-	endTime := secondsToTimeMask(endAt)
+	h := sha256.Sum256(data)
+	return data, hex.EncodeToString(h[:]), nil
+}
 
-	filename := fmt.Sprintf("%d-%s-%s.mp4", vodID, startTime, endTime)
+func buildOutFilePath(tmpl string, data FilenameData, prefix string, folder string) (string, error) {
+	filename, err := renderFilename(tmpl, data)
+	if err != nil {
+		return "", err
+	}
 
 	if len(prefix) > 0 {
-		filename = prefix + filename
+		// the prefix goes on the file, not on any folders from the template
+		filename = filepath.Join(filepath.Dir(filename), prefix+filepath.Base(filename))
 	}
 
 	if len(folder) > 0 {
@@ -512,38 +1443,6 @@ func buildOutFilePath(vodID int, startAt int, dur int, prefix string, folder str
 	return filename, nil
 }
 
-func combineChunks(chunks []Chunk, outfile string) error {
-	of, err := os.Create(outfile)
-	if err != nil {
-		return err
-	}
-	defer of.Close()
-
-	bar := progressbar.Default(int64(len(chunks)))
-	for _, c := range chunks {
-		cf, err := os.Open(c.Path)
-		if err != nil {
-			return err
-		}
-
-		_, err = io.Copy(of, cf)
-		cf.Close()
-		if err != nil {
-			return err
-		}
-		err = bar.Add(1)
-		if err != nil {
-			return fmt.Errorf("error: failed to increment progress bar: %w", err)
-		}
-	}
-	err = bar.Finish()
-	if err != nil {
-		return fmt.Errorf("error: failed to increment progress bar: %w", err)
-	}
-
-	return nil
-}
-
 func timeInputToSeconds(t string) (int, error) {
 	entries := strings.Split(t, " ")
 	if len(entries) != 3 {
@@ -576,3 +1475,15 @@ func secondsToTimeMask(s int) string {
 	log.Printf("masked %d seconds as '%s'\n", s, res)
 	return res
 }
+
+// formatSeconds is secondsToTimeMask keeping any fractional seconds (to the
+// millisecond), e.g. "00h01m02.5s"
+func formatSeconds(s float64) string {
+	ms := int64(math.Round(s * 1000))
+	if ms%1000 == 0 {
+		return secondsToTimeMask(int(ms / 1000))
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%03d", ms%1000), "0")
+	whole := strings.TrimSuffix(secondsToTimeMask(int(ms/1000)), "s")
+	return fmt.Sprintf("%s.%ss", whole, frac)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testChunks builds a chunk list with the given lengths, named after their
+// position in it
+func testChunks(lengths ...float64) []Chunk {
+	chunks := make([]Chunk, len(lengths))
+	start := 0.0
+	for i, l := range lengths {
+		chunks[i] = Chunk{Index: i, Name: fmt.Sprintf("%d.ts", i), Length: l, SeqNo: uint64(i), Start: start}
+		start += l
+	}
+	return chunks
+}
+
+func TestPruneChunks(t *testing.T) {
+	regular := testChunks(10, 10, 10, 10, 10, 10)
+
+	tests := []struct {
+		name      string
+		chunks    []Chunk
+		startSec  int
+		endSec    int
+		padding   float64
+		want      []string
+		wantStart float64
+		wantDur   float64
+		wantErr   bool
+	}{
+		{"whole VOD", regular, 0, -1, 0, []string{"0.ts", "1.ts", "2.ts", "3.ts", "4.ts", "5.ts"}, 0, 60, false},
+		{"range on chunk boundaries", regular, 20, 40, 0, []string{"2.ts", "3.ts"}, 20, 20, false},
+		{"range inside chunks", regular, 25, 35, 0, []string{"2.ts", "3.ts"}, 20, 20, false},
+		{"range inside one chunk", regular, 21, 29, 0, []string{"2.ts"}, 20, 10, false},
+		{"to the end", regular, 45, -1, 0, []string{"4.ts", "5.ts"}, 40, 20, false},
+		{"end past the end of the VOD", regular, 50, 1000, 0, []string{"5.ts"}, 50, 10, false},
+		{"padding", regular, 20, 40, 5, []string{"1.ts", "2.ts", "3.ts", "4.ts"}, 10, 40, false},
+		{"padding before the start of the VOD", regular, 5, 15, 10, []string{"0.ts", "1.ts", "2.ts"}, 0, 30, false},
+		{"irregular lengths", testChunks(10, 4.5, 10, 2.002, 10), 14, 16, 0, []string{"1.ts", "2.ts"}, 10, 14.5, false},
+		{"short chunk at the start of the range", testChunks(10, 4.5, 10, 2.002, 10), 25, 26, 0, []string{"3.ts"}, 24.5, 2.002, false},
+		{"start past the end of the VOD", regular, 60, -1, 0, nil, 0, 0, true},
+		{"empty playlist", nil, 0, -1, 0, nil, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, start, dur, err := pruneChunks(tt.chunks, tt.startSec, tt.endSec, tt.padding)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pruneChunks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var names []string
+			for i, c := range got {
+				names = append(names, c.Name)
+				if c.Index != i {
+					t.Errorf("pruneChunks() chunk %s has index %d, want %d", c.Name, c.Index, i)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("pruneChunks() chunks = %v, want %v", names, tt.want)
+			}
+			if math.Abs(start-tt.wantStart) > 1e-9 || math.Abs(dur-tt.wantDur) > 1e-9 {
+				t.Errorf("pruneChunks() start, duration = %g, %g, want %g, %g", start, dur, tt.wantStart, tt.wantDur)
+			}
+		})
+	}
+}
+
+func TestJoinStdoutArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"no args", []string{}, []string{}},
+		{"short flag", []string{"-o", "-"}, []string{"-o-"}},
+		{"long flag", []string{"--output", "-", "123"}, []string{"--output=-", "123"}},
+		{"among other args", []string{"hls", "index.m3u8", "-o", "-", "-Q", "best"}, []string{"hls", "index.m3u8", "-o-", "-Q", "best"}},
+		{"already joined", []string{"-o-", "--output=-"}, []string{"-o-", "--output=-"}},
+		{"output to a file", []string{"-o", "clip.mp4"}, []string{"-o", "clip.mp4"}},
+		{"flag without a value", []string{"123", "-o"}, []string{"123", "-o"}},
+		{"lone dash", []string{"-", "-o"}, []string{"-", "-o"}},
+		{"after --", []string{"-Q", "best", "--", "-o", "-"}, []string{"-Q", "best", "--", "-o", "-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinStdoutArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("joinStdoutArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchChunkCancel(t *testing.T) {
+	payload := append([]byte{TSSyncByte}, make([]byte, TSPacketSize-1)...)
+
+	tests := []struct {
+		name         string
+		statuses     []int // served in turn, then the last one repeats
+		cancel       bool
+		wantAttempts int
+		wantErr      error
+	}{
+		{"retried until it succeeds", []int{503, 200}, false, 2, nil},
+		{"attempt in flight finishes after cancelling", []int{200}, true, 1, nil},
+		{"retry abandoned after cancelling", []int{503}, true, 1, context.Canceled},
+		{"fatal error not retried", []int{404}, false, 1, &HTTPStatusError{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(atomic.AddInt32(&requests, 1)) - 1
+				if i >= len(tt.statuses) {
+					i = len(tt.statuses) - 1
+				}
+				w.WriteHeader(tt.statuses[i])
+				_, _ = w.Write(payload)
+			}))
+			defer srv.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			// after cancelling, the hour-long delay can only end by giving up
+			delay := time.Millisecond
+			if tt.cancel {
+				delay = time.Hour
+			}
+			dl := &downloader{
+				client: srv.Client(),
+				stats:  &poolStats{},
+				retry:  RetryPolicy{MaxAttempts: 3, BaseDelay: delay, MaxDelay: delay},
+			}
+			u, _ := url.Parse(srv.URL + "/0.ts")
+
+			res := dl.fetchChunk(ctx, 1, Chunk{Name: "0.ts", URL: u})
+			if res.Attempts != tt.wantAttempts {
+				t.Errorf("fetchChunk() made %d attempts, want %d", res.Attempts, tt.wantAttempts)
+			}
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if res.Err != nil || !bytes.Equal(res.Data, payload) {
+					t.Errorf("fetchChunk() = %d bytes, %v, want the payload", len(res.Data), res.Err)
+				}
+			case *HTTPStatusError:
+				if !errors.As(res.Err, &want) {
+					t.Errorf("fetchChunk() error = %v, want an HTTPStatusError", res.Err)
+				}
+			default:
+				if !errors.Is(res.Err, want) {
+					t.Errorf("fetchChunk() error = %v, want %v", res.Err, want)
+				}
+			}
+		})
+	}
+}
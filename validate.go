@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// TSPacketSize is the size of an MPEG-TS packet
+	TSPacketSize = 188
+	// TSSyncByte is the byte every MPEG-TS packet starts with
+	TSSyncByte = 0x47
+)
+
+// ValidationError is returned when a downloaded chunk does not look like the
+// payload we asked for (e.g. an HTML error page served with a 200)
+type ValidationError struct {
+	Chunk  string
+	Reason string
+	// Permanent is set when fetching the chunk again would give the same
+	// result (e.g. it decrypts to garbage or has no audio), as opposed to a
+	// payload mangled or cut short on the way
+	Permanent bool
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("error: chunk %s failed validation: %s", e.Chunk, e.Reason)
+}
+
+// tsValidator is an io.Writer that checks a stream of bytes is MPEG-TS by
+// verifying the sync byte at the start of every 188-byte packet
+type tsValidator struct {
+	chunk  string
+	offset int64
+	err    error
+}
+
+func (v *tsValidator) Write(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+
+	// index in p of the next packet start
+	next := (TSPacketSize - v.offset%TSPacketSize) % TSPacketSize
+	for i := next; i < int64(len(p)); i += TSPacketSize {
+		if p[i] != TSSyncByte {
+			v.err = &ValidationError{
+				Chunk:  v.chunk,
+				Reason: fmt.Sprintf("missing MPEG-TS sync byte at offset %d (got 0x%02x)", v.offset+i, p[i]),
+			}
+			return 0, v.err
+		}
+	}
+	v.offset += int64(len(p))
+
+	return len(p), nil
+}
+
+// Close reports whether the stream as a whole was valid
+func (v *tsValidator) Close() error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.offset == 0 {
+		return &ValidationError{Chunk: v.chunk, Reason: "empty payload"}
+	}
+	return nil
+}
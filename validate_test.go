@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTSValidator(t *testing.T) {
+	packet := append([]byte{TSSyncByte}, bytes.Repeat([]byte{0xff}, TSPacketSize-1)...)
+	stream := bytes.Repeat(packet, 3)
+	broken := append([]byte(nil), stream...)
+	broken[TSPacketSize*2] = 0x00
+
+	tests := []struct {
+		name    string
+		writes  [][]byte
+		wantErr bool
+	}{
+		{"one write", [][]byte{stream}, false},
+		{"write per packet", [][]byte{stream[:TSPacketSize], stream[TSPacketSize : 2*TSPacketSize], stream[2*TSPacketSize:]}, false},
+		{"writes split mid-packet", [][]byte{stream[:100], stream[100:400], stream[400:]}, false},
+		{"single bytes around a packet start", [][]byte{stream[:TSPacketSize-1], stream[TSPacketSize-1 : TSPacketSize], stream[TSPacketSize : TSPacketSize+1], stream[TSPacketSize+1:]}, false},
+		{"trailing partial packet", [][]byte{stream[:TSPacketSize+10]}, false},
+		{"empty", nil, true},
+		{"HTML error page", [][]byte{[]byte("<html><body>Service Unavailable</body></html>")}, true},
+		{"bad sync byte in a later packet", [][]byte{broken}, true},
+		{"bad sync byte at the start of a later write", [][]byte{broken[:2*TSPacketSize], broken[2*TSPacketSize:]}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &tsValidator{chunk: "0.ts"}
+			var err error
+			for _, p := range tt.writes {
+				var n int
+				n, err = v.Write(p)
+				if err != nil {
+					break
+				}
+				if n != len(p) {
+					t.Fatalf("Write() = %d, want %d", n, len(p))
+				}
+			}
+			closeErr := v.Close()
+			if (closeErr != nil) != tt.wantErr {
+				t.Fatalf("Close() error = %v, wantErr %v", closeErr, tt.wantErr)
+			}
+			if err != nil && closeErr != err {
+				t.Errorf("Close() error = %v, want the Write() error %v", closeErr, err)
+			}
+
+			if closeErr != nil {
+				var validationErr *ValidationError
+				if !errors.As(closeErr, &validationErr) {
+					t.Errorf("Close() error = %v, want a ValidationError", closeErr)
+				}
+				// a mangled payload may come through fine on a retry
+				if !isRetryable(closeErr) {
+					t.Errorf("Close() error = %v is permanent, want it retryable", closeErr)
+				}
+			}
+		})
+	}
+}
+
+func TestTSValidatorStopsAfterError(t *testing.T) {
+	v := &tsValidator{chunk: "0.ts"}
+	_, err := v.Write([]byte("not a TS packet"))
+	if err == nil {
+		t.Fatal("Write() of garbage succeeded")
+	}
+
+	packet := append([]byte{TSSyncByte}, make([]byte, TSPacketSize-1)...)
+	n, err := v.Write(packet)
+	if err == nil || n != 0 {
+		t.Errorf("Write() after an error = %d, %v, want 0 and the earlier error", n, err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// Variant is one of the renditions in a master playlist, built from its
+// EXT-X-STREAM-INF tag and the EXT-X-MEDIA tag of its video group
+type Variant struct {
+	// Name is the rendition's NAME from EXT-X-MEDIA (e.g. "720p60" or
+	// "1080p60 (source)"), or one made up from its resolution and framerate
+	Name string
+	// Group is its video GROUP-ID (e.g. "720p60", or "chunked" for Twitch's
+	// source quality)
+	Group      string
+	Resolution string
+	Width      int
+	Height     int
+	FrameRate  float64
+	Bandwidth  uint32
+	Codecs     string
+	URI        string
+	// AudioOnly is set for renditions without video
+	AudioOnly bool
+}
+
+// newVariants builds the variants of a master playlist, with their URIs
+// resolved against the playlist's URL
+func newVariants(masterPl *m3u8.MasterPlaylist, playlistURL string) []*Variant {
+	// "safe" to ignore - previously fetched
+	baseURL, _ := url.Parse(playlistURL)
+
+	// EXT-X-MEDIA tags are attached to the EXT-X-STREAM-INF that follows
+	// them, which needn't be the one that uses them, so gather them all first
+	names := make(map[string]string)
+	for _, v := range masterPl.Variants {
+		for _, alt := range v.Alternatives {
+			if strings.EqualFold(alt.Type, "VIDEO") && alt.Name != "" {
+				if _, ok := names[alt.GroupId]; !ok {
+					names[alt.GroupId] = alt.Name
+				}
+			}
+		}
+	}
+
+	var variants []*Variant
+	for _, v := range masterPl.Variants {
+		if v.Iframe {
+			continue
+		}
+		variant := &Variant{
+			Name:       names[v.Video],
+			Group:      v.Video,
+			Resolution: v.Resolution,
+			FrameRate:  v.FrameRate,
+			Bandwidth:  v.Bandwidth,
+			Codecs:     v.Codecs,
+			URI:        v.URI,
+		}
+		if u, err := url.Parse(v.URI); err == nil {
+			variant.URI = baseURL.ResolveReference(u).String()
+		}
+		if i := strings.IndexByte(v.Resolution, 'x'); i > 0 {
+			variant.Width, _ = strconv.Atoi(v.Resolution[:i])
+			variant.Height, _ = strconv.Atoi(v.Resolution[i+1:])
+		}
+		variant.AudioOnly = v.Video == audioOnlyGroup || (v.Codecs != "" && variant.Height == 0 && videoCodec(v.Codecs) == "")
+		if variant.Name == "" {
+			variant.Name = variant.shortName()
+		}
+		variants = append(variants, variant)
+	}
+
+	return variants
+}
+
+// shortName names a variant after its height and framerate, as Twitch does
+// (e.g. "720p60")
+func (v *Variant) shortName() string {
+	switch {
+	case v.AudioOnly:
+		return AudioQuality
+	case v.Height == 0:
+		return fmt.Sprintf("%dk", v.Bandwidth/1000)
+	case v.FrameRate > 0:
+		return fmt.Sprintf("%dp%d", v.Height, int(math.Round(v.FrameRate)))
+	}
+	return fmt.Sprintf("%dp", v.Height)
+}
+
+// Codec returns the family of the variant's video codec (see videoCodec)
+func (v *Variant) Codec() string {
+	return videoCodec(v.Codecs)
+}
+
+// Names returns every name the variant can be picked by, in lower case: its
+// NAME (with and without a " (source)" suffix), GROUP-ID, short name, height,
+// and resolution
+func (v *Variant) Names() []string {
+	names := []string{v.Name, strings.TrimSuffix(v.Name, " (source)"), v.Group, v.shortName()}
+	if v.Height > 0 {
+		names = append(names, fmt.Sprintf("%dp", v.Height), v.Resolution)
+	}
+
+	var res []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n != "" && !seen[n] {
+			seen[n] = true
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// Key identifies the rendition across runs, since several can share a name:
+// its name plus a short hash of its playlist's location (without the query
+// string, which often holds expiring tokens)
+func (v *Variant) Key() string {
+	loc := v.URI
+	if u, err := url.Parse(v.URI); err == nil {
+		loc = u.Scheme + "://" + u.Host + u.Path
+	}
+	sum := sha1.Sum([]byte(loc))
+	return fmt.Sprintf("%s-%s", v.Name, hex.EncodeToString(sum[:])[:8])
+}
+
+func (v *Variant) String() string {
+	parts := []string{}
+	if v.Resolution != "" {
+		parts = append(parts, v.Resolution)
+	}
+	if v.FrameRate > 0 {
+		parts = append(parts, fmt.Sprintf("%gfps", math.Round(v.FrameRate*100)/100))
+	}
+	parts = append(parts, fmt.Sprintf("%d bps", v.Bandwidth))
+	if v.Codecs != "" {
+		parts = append(parts, v.Codecs)
+	}
+	return fmt.Sprintf("%s (%s)", v.Name, strings.Join(parts, ", "))
+}
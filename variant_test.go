@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+// testMasterPlaylist is laid out like Twitch's: each EXT-X-MEDIA comes right
+// before the EXT-X-STREAM-INF using its group, except the 720p30 one, which
+// comes first
+const testMasterPlaylist = `#EXTM3U
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="720p30",NAME="720p30",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="chunked",NAME="1080p60 (source)",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-STREAM-INF:BANDWIDTH=8000000,RESOLUTION=1920x1080,CODECS="avc1.64002A,mp4a.40.2",VIDEO="chunked",FRAME-RATE=60.000
+https://cdn.example.com/vod/chunked/index-dvr.m3u8?token=abc
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="720p60",NAME="720p60",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-STREAM-INF:BANDWIDTH=3400000,RESOLUTION=1280x720,CODECS="avc1.4D401F,mp4a.40.2",VIDEO="720p60",FRAME-RATE=59.940
+720p60/index-dvr.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2300000,RESOLUTION=1280x720,CODECS="avc1.4D401F,mp4a.40.2",VIDEO="720p30",FRAME-RATE=30.000
+720p30/index-dvr.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=852x480,CODECS="avc1.4D401E,mp4a.40.2"
+../480p/index-dvr.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,RESOLUTION=1280x720,URI="iframes.m3u8"
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="audio_only",NAME="Audio Only",AUTOSELECT=NO,DEFAULT=NO
+#EXT-X-STREAM-INF:BANDWIDTH=160000,CODECS="mp4a.40.2",VIDEO="audio_only"
+audio_only/index-dvr.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=96000,CODECS="mp4a.40.2"
+audio/index.m3u8
+`
+
+func TestNewVariants(t *testing.T) {
+	p, listType, err := m3u8.DecodeFrom(strings.NewReader(testMasterPlaylist), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != m3u8.MASTER {
+		t.Fatalf("test playlist decoded as %v, want a master playlist", listType)
+	}
+
+	variants := newVariants(p.(*m3u8.MasterPlaylist), "https://cdn.example.com/vod/master.m3u8")
+
+	// in playlist order, without the I-frame stream
+	want := []struct {
+		name      string
+		group     string
+		height    int
+		frameRate float64
+		uri       string
+		audioOnly bool
+	}{
+		{"1080p60 (source)", "chunked", 1080, 60, "https://cdn.example.com/vod/chunked/index-dvr.m3u8?token=abc", false},
+		{"720p60", "720p60", 720, 59.94, "https://cdn.example.com/vod/720p60/index-dvr.m3u8", false},
+		{"720p30", "720p30", 720, 30, "https://cdn.example.com/vod/720p30/index-dvr.m3u8", false},
+		{"480p", "", 480, 0, "https://cdn.example.com/480p/index-dvr.m3u8", false},
+		{"Audio Only", audioOnlyGroup, 0, 0, "https://cdn.example.com/vod/audio_only/index-dvr.m3u8", true},
+		{AudioQuality, "", 0, 0, "https://cdn.example.com/vod/audio/index.m3u8", true},
+	}
+	if len(variants) != len(want) {
+		t.Fatalf("newVariants() returned %d variants, want %d: %v", len(variants), len(want), variants)
+	}
+	for i, w := range want {
+		v := variants[i]
+		if v.Name != w.name || v.Group != w.group || v.Height != w.height || v.FrameRate != w.frameRate || v.URI != w.uri || v.AudioOnly != w.audioOnly {
+			t.Errorf("variant %d = %+v, want %+v", i, *v, w)
+		}
+	}
+}
+
+func TestVariantNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant Variant
+		want    []string
+	}{
+		{"source", Variant{Name: "1080p60 (source)", Group: "chunked", Resolution: "1920x1080", Height: 1080, FrameRate: 60}, []string{"1080p60 (source)", "1080p60", "chunked", "1080p", "1920x1080"}},
+		{"named after its group", Variant{Name: "720p30", Group: "720p30", Resolution: "1280x720", Height: 720, FrameRate: 30}, []string{"720p30", "720p", "1280x720"}},
+		{"no framerate", Variant{Name: "480p", Resolution: "852x480", Height: 480}, []string{"480p", "852x480"}},
+		{"audio only", Variant{Name: "Audio Only", Group: audioOnlyGroup, AudioOnly: true}, []string{"audio only", audioOnlyGroup, AudioQuality}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.variant.Names()
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Names() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     Variant
+		wantSame bool
+	}{
+		{"new token", Variant{Name: "720p60", URI: "https://cdn.example.com/vod/720p60/index.m3u8?token=a"}, Variant{Name: "720p60", URI: "https://cdn.example.com/vod/720p60/index.m3u8?token=b"}, true},
+		{"same name, another rendition", Variant{Name: "1080p60", URI: "https://cdn.example.com/vod/chunked/index.m3u8"}, Variant{Name: "1080p60", URI: "https://cdn.example.com/vod/1080p60_hevc/index.m3u8"}, false},
+		{"same playlist, another name", Variant{Name: "720p60", URI: "https://cdn.example.com/vod/index.m3u8"}, Variant{Name: "720p", URI: "https://cdn.example.com/vod/index.m3u8"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a.Key(), tt.b.Key()
+			if !strings.HasPrefix(a, tt.a.Name+"-") {
+				t.Errorf("Key() = %q, want it to start with the name", a)
+			}
+			if (a == b) != tt.wantSame {
+				t.Errorf("Key() = %q and %q, want same: %v", a, b, tt.wantSame)
+			}
+		})
+	}
+}
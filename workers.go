@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AutoWorkers is the WorkerCount value for an adaptive worker pool
+const AutoWorkers WorkerCount = -1
+
+// WorkerCount is either a fixed number of workers or AutoWorkers. It accepts
+// both integers and the string "auto" in the config file.
+type WorkerCount int
+
+// UnmarshalText parses "auto" or a positive integer
+func (w *WorkerCount) UnmarshalText(text []byte) error {
+	n, err := parseWorkerCount(string(text))
+	if err != nil {
+		return err
+	}
+	*w = n
+	return nil
+}
+
+// MarshalText writes "auto" or the number of workers
+func (w WorkerCount) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w WorkerCount) String() string {
+	if w == AutoWorkers {
+		return "auto"
+	}
+	return strconv.Itoa(int(w))
+}
+
+func parseWorkerCount(s string) (WorkerCount, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "auto") {
+		return AutoWorkers, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("error: Workers must be 'auto' or an integer; got '%s'", s)
+	}
+	return WorkerCount(n), nil
+}
+
+const (
+	// scaleInterval is how often the auto scaler re-evaluates the pool
+	scaleInterval = 5 * time.Second
+	// scaleMinGain is the throughput improvement needed to justify a worker
+	scaleMinGain = 1.05
+	// scaleMaxErrorRate is the share of failed attempts that makes the scaler
+	// back off, as it usually means the CDN is throttling us
+	scaleMaxErrorRate = 0.1
+	// scaleHoldIntervals is how long the scaler holds once adding a worker
+	// stops helping before it probes again
+	scaleHoldIntervals = 6
+)
+
+// poolStats collects throughput and error counts from the workers
+type poolStats struct {
+	mu       sync.Mutex
+	bytes    int64
+	attempts int
+	failures int
+}
+
+func (s *poolStats) record(bytes int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes += bytes
+	s.attempts++
+	if err != nil {
+		s.failures++
+	}
+}
+
+func (s *poolStats) snapshot() (int64, int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes, s.attempts, s.failures
+}
+
+// autoScaler grows and shrinks a worker pool between min and max workers by
+// hill-climbing on observed throughput, backing off when errors pile up
+type autoScaler struct {
+	min, max int
+	stats    *poolStats
+	// spawn starts one more worker
+	spawn func()
+	// retire asks one worker to stop after its current chunk
+	retire chan struct{}
+	// pending reports how many chunks have not been picked up yet
+	pending func() int
+
+	workers int
+	peak    int
+
+	// state carried between decisions
+	prevThroughput float64
+	grew           bool
+	hold           int
+}
+
+// run adjusts the pool every scaleInterval until ctx is cancelled or there are
+// no chunks left to hand out
+func (a *autoScaler) run(ctx context.Context) {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	lastBytes, lastAttempts, lastFailures := a.stats.snapshot()
+	lastTime := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if a.pending() == 0 {
+				return
+			}
+
+			bytes, attempts, failures := a.stats.snapshot()
+			throughput := float64(bytes-lastBytes) / now.Sub(lastTime).Seconds()
+			errorRate := 0.0
+			if attempts > lastAttempts {
+				errorRate = float64(failures-lastFailures) / float64(attempts-lastAttempts)
+			}
+			lastBytes, lastAttempts, lastFailures, lastTime = bytes, attempts, failures, now
+
+			a.adjust(throughput, errorRate)
+		}
+	}
+}
+
+// adjust makes one scaling decision from the throughput (bytes per second)
+// and error rate seen since the last one
+func (a *autoScaler) adjust(throughput, errorRate float64) {
+	switch {
+	case errorRate > scaleMaxErrorRate:
+		// at the minimum already, this only holds off growing
+		a.shrink(fmt.Sprintf("error rate %.0f%%", errorRate*100))
+		a.grew = false
+		a.hold = scaleHoldIntervals
+	case a.grew && throughput < a.prevThroughput*scaleMinGain:
+		a.shrink(fmt.Sprintf("throughput %s/s did not improve", formatBytes(int64(throughput))))
+		a.grew = false
+		a.hold = scaleHoldIntervals
+	case a.hold > 0:
+		a.hold--
+		a.grew = false
+	case a.workers < a.max:
+		a.grow(fmt.Sprintf("throughput %s/s", formatBytes(int64(throughput))))
+		a.grew = true
+	default:
+		a.grew = false
+	}
+	a.prevThroughput = throughput
+}
+
+func (a *autoScaler) grow(reason string) {
+	a.spawn()
+	a.workers++
+	if a.workers > a.peak {
+		a.peak = a.workers
+	}
+	log.Printf("auto workers: scaling up to %d (%s)", a.workers, reason)
+}
+
+func (a *autoScaler) shrink(reason string) {
+	if a.workers <= a.min {
+		return
+	}
+	a.retire <- struct{}{}
+	a.workers--
+	log.Printf("auto workers: scaling down to %d (%s)", a.workers, reason)
+}
+
+// formatBytes renders a byte count using binary units (e.g. "1.5M")
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseWorkerCount(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    WorkerCount
+		wantErr bool
+	}{
+		{"4", 4, false},
+		{" 8 ", 8, false},
+		{"auto", AutoWorkers, false},
+		{"AUTO", AutoWorkers, false},
+		{"", 0, true},
+		{"many", 0, true},
+		{"2.5", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseWorkerCount(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWorkerCount(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseWorkerCount(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+// scaleStep is the throughput and error rate seen by one scaling decision,
+// and the number of workers wanted after it
+type scaleStep struct {
+	throughput  float64
+	errorRate   float64
+	wantWorkers int
+}
+
+// holdSteps returns the steps for the hold that follows a shrink, during which
+// the pool stays at workers even though throughput keeps climbing
+func holdSteps(workers int) []scaleStep {
+	steps := make([]scaleStep, scaleHoldIntervals)
+	for i := range steps {
+		steps[i] = scaleStep{float64(1000 * (i + 1)), 0, workers}
+	}
+	return steps
+}
+
+func TestAutoScalerAdjust(t *testing.T) {
+	tests := []struct {
+		name    string
+		min     int
+		max     int
+		workers int
+		steps   []scaleStep
+	}{
+		{
+			name: "grows while throughput improves",
+			min:  1, max: 4, workers: 1,
+			steps: []scaleStep{{100, 0, 2}, {200, 0, 3}, {300, 0, 4}, {400, 0, 4}},
+		},
+		{
+			name: "gives back a worker that didn't help, then holds",
+			min:  1, max: 4, workers: 1,
+			steps: append(append([]scaleStep{{100, 0, 2}, {102, 0, 1}}, holdSteps(1)...), scaleStep{100, 0, 2}),
+		},
+		{
+			name: "backs off on errors",
+			min:  1, max: 4, workers: 3,
+			steps: []scaleStep{{100, 0.5, 2}, {100, 0.5, 1}, {100, 0.5, 1}},
+		},
+		{
+			name: "doesn't grow on errors at the minimum",
+			min:  1, max: 4, workers: 1,
+			steps: append([]scaleStep{{100, 0.5, 1}}, holdSteps(1)...),
+		},
+		{
+			name: "tolerates a few errors",
+			min:  1, max: 4, workers: 1,
+			steps: []scaleStep{{100, scaleMaxErrorRate, 2}},
+		},
+		{
+			name: "stays put at the maximum",
+			min:  1, max: 2, workers: 2,
+			steps: []scaleStep{{100, 0, 2}, {50, 0, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spawned := 0
+			a := &autoScaler{
+				min:     tt.min,
+				max:     tt.max,
+				spawn:   func() { spawned++ },
+				retire:  make(chan struct{}, tt.max),
+				workers: tt.workers,
+				peak:    tt.workers,
+			}
+			for i, s := range tt.steps {
+				a.adjust(s.throughput, s.errorRate)
+				if a.workers != s.wantWorkers {
+					t.Fatalf("step %d: adjust(%v, %v) left %d workers, want %d", i, s.throughput, s.errorRate, a.workers, s.wantWorkers)
+				}
+			}
+			// every change must have reached the pool
+			if got := tt.workers + spawned - len(a.retire); got != a.workers {
+				t.Errorf("pool has %d workers, scaler thinks %d", got, a.workers)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0K"},
+		{1536, "1.5K"},
+		{5 * 1024 * 1024, "5.0M"},
+		{3 * 1024 * 1024 * 1024, "3.0G"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatBytes(tt.n); got != tt.want {
+				t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+			}
+		})
+	}
+}